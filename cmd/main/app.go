@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
 	"myLibrary/internal/config"
 	"myLibrary/internal/user"
 	"myLibrary/package/client/database"
+	"myLibrary/package/client/mailer"
 	"myLibrary/package/logger"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -19,23 +24,50 @@ func main() {
 	logger.Log.Info("Starting database")
 	db := database.Init(cfg)
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := runMigrate(db, os.Args[2:])
+		db.Close()
+		if err != nil {
+			logger.Log.Fatal(err)
+		}
+		return
+	}
+
+	prepareSchema(db, cfg)
+
+	if len(os.Args) > 1 && os.Args[1] == "role" {
+		err := runRole(db, os.Args[2:])
+		db.Close()
+		if err != nil {
+			logger.Log.Fatal(err)
+		}
+		return
+	}
+
 	router := httprouter.New()
 
-	handler := user.NewHandler(db, cfg)
+	handler := user.NewHandler(user.NewPostgresRepositories(db), mailer.Init(cfg), cfg)
 	handler.Register(router)
 
 	defer func(db *sql.DB) {
 		err := db.Close()
 		if err != nil {
 			logger.Log.Error("Can not close database")
+			return
 		}
+		logger.Log.Info("Database closed")
 	}(db)
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	logger.Log.Info("Starting app")
-	start(router, cfg)
+	start(ctx, router, cfg)
 }
 
-func start(router *httprouter.Router, cfg *config.Config) {
+// start serves until ctx is cancelled by SIGINT/SIGTERM, then stops accepting
+// connections and waits up to listen.shutdown_timeout for in-flight requests.
+func start(ctx context.Context, router *httprouter.Router, cfg *config.Config) {
 	logger.Log.Info("Starting router")
 	logger.Log.Info("Listening TCP")
 	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%s", cfg.Listen.BindIp, cfg.Listen.Port))
@@ -51,9 +83,26 @@ func start(router *httprouter.Router, cfg *config.Config) {
 		ReadTimeout:  15 * time.Second,
 	}
 
-	err = server.Serve(listener)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err = <-serveErr:
+		logger.Log.Error("Server stopped: " + err.Error())
+		return
+	case <-ctx.Done():
+	}
+
+	logger.Log.Info(fmt.Sprintf("Shutting down, draining connections for up to %s", cfg.Listen.ShutdownTimeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Listen.ShutdownTimeout)
+	defer cancel()
+
+	err = server.Shutdown(shutdownCtx)
 	if err != nil {
-		logger.Log.Fatal("Server was not created")
-		panic(err)
+		logger.Log.Error("Connections were not drained in time: " + err.Error())
+		return
 	}
+	logger.Log.Info("Server stopped")
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"myLibrary/internal/config"
+	"myLibrary/package/client/database"
+	"myLibrary/package/logger"
+	"strconv"
+)
+
+const migrateUsage = "usage: app migrate [up | down [steps] | status]"
+
+// runMigrate handles the "migrate" subcommand.
+func runMigrate(db *sql.DB, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		if err := database.Migrate(db); err != nil {
+			return err
+		}
+		logger.Log.Info("Database schema is up to date")
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return errors.New(migrateUsage)
+			}
+		}
+		return database.Rollback(db, steps)
+	case "status":
+		statuses, err := database.Status(db)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, state)
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
+
+// prepareSchema applies pending migrations on startup, or only verifies the
+// schema when auto_migrate is off. The app never starts against a schema
+// that is ahead of the binary.
+func prepareSchema(db *sql.DB, cfg *config.Config) {
+	var err error
+	if cfg.Storage.AutoMigrate {
+		logger.Log.Info("Applying database migrations")
+		err = database.Migrate(db)
+	} else {
+		err = database.CheckSchema(db)
+	}
+	if err != nil {
+		logger.Log.Error(err)
+		logger.Log.Fatal("Database schema is not compatible with this version")
+	}
+}
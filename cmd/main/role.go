@@ -0,0 +1,33 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"myLibrary/internal/user"
+	"myLibrary/package/logger"
+)
+
+const roleUsage = "usage: app role <email> <user | admin>"
+
+// runRole handles the "role" subcommand, which is how the first admin is made.
+func runRole(db *sql.DB, args []string) error {
+	if len(args) != 2 {
+		return errors.New(roleUsage)
+	}
+	role, err := user.ParseRole(args[1])
+	if err != nil {
+		return errors.New(roleUsage)
+	}
+
+	users := user.NewPostgresRepositories(db).Users
+	target, err := users.ByEmail(args[0])
+	if err != nil {
+		return err
+	}
+	err = users.SetRole(target.ID, role)
+	if err != nil {
+		return err
+	}
+	logger.Log.Info("User " + target.ID + " now has role " + string(role))
+	return nil
+}
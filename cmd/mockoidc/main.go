@@ -0,0 +1,261 @@
+// Command mockoidc is a minimal OpenID Connect provider for trying OIDC login
+// locally. It approves every authorization request without asking, as the
+// user given by the flags, or by the login_hint email when the request has one.
+//
+//	go run ./cmd/mockoidc -listen 127.0.0.1:9000
+//
+// and in config.yml:
+//
+//	oidc:
+//	  issuer: http://127.0.0.1:9000
+//	  client_id: mylibrary
+//	  client_secret: mock-secret
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"github.com/dgrijalva/jwt-go"
+	"math/big"
+	"myLibrary/internal/oidc"
+	"myLibrary/internal/signing"
+	"myLibrary/package/logger"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	keyID   = "mock-1"
+	codeTTL = time.Minute
+)
+
+type grant struct {
+	clientID      string
+	redirectURI   string
+	challenge     string
+	nonce         string
+	subject       string
+	email         string
+	emailVerified bool
+	expiresAt     time.Time
+}
+
+type provider struct {
+	issuer        string
+	clientID      string
+	clientSecret  string
+	subject       string
+	email         string
+	emailVerified bool
+	key           *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]*grant
+}
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:9000", "address to listen on")
+	issuer := flag.String("issuer", "", "issuer URL, http://<listen> by default")
+	clientID := flag.String("client-id", "mylibrary", "the only accepted client_id")
+	clientSecret := flag.String("client-secret", "mock-secret", "its client secret")
+	subject := flag.String("sub", "mock-user-1", "subject of the signed-in user")
+	email := flag.String("email", "oidc.user@example.com", "email of the signed-in user")
+	emailVerified := flag.Bool("email-verified", true, "whether the email is reported as verified")
+	flag.Parse()
+
+	if *issuer == "" {
+		*issuer = "http://" + *listen
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		logger.Log.Fatal(err)
+	}
+
+	p := &provider{
+		issuer:        strings.TrimSuffix(*issuer, "/"),
+		clientID:      *clientID,
+		clientSecret:  *clientSecret,
+		subject:       *subject,
+		email:         *email,
+		emailVerified: *emailVerified,
+		key:           key,
+		grants:        make(map[string]*grant),
+	}
+
+	logger.Log.Info("Mock OIDC provider " + p.issuer + " listening on " + *listen)
+	logger.Log.Fatal(http.ListenAndServe(*listen, p.routes()))
+}
+
+func (p *provider) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+	return mux
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != p.clientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	reject := func(code string) {
+		values := redirectURI.Query()
+		values.Set("error", code)
+		values.Set("state", query.Get("state"))
+		redirectURI.RawQuery = values.Encode()
+		http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+	}
+	if query.Get("response_type") != "code" {
+		reject("unsupported_response_type")
+		return
+	}
+	if query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256" {
+		reject("invalid_request")
+		return
+	}
+
+	g := &grant{
+		clientID:      p.clientID,
+		redirectURI:   query.Get("redirect_uri"),
+		challenge:     query.Get("code_challenge"),
+		nonce:         query.Get("nonce"),
+		subject:       p.subject,
+		email:         p.email,
+		emailVerified: p.emailVerified,
+		expiresAt:     time.Now().Add(codeTTL),
+	}
+	if hint := query.Get("login_hint"); hint != "" {
+		g.subject = "mock-" + hint
+		g.email = hint
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.grants[code] = g
+	p.mu.Unlock()
+
+	values := redirectURI.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirectURI.RawQuery = values.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.clientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(p.clientSecret)) != 1 {
+		tokenError(w, "invalid_client")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+
+	p.mu.Lock()
+	g, found := p.grants[r.PostForm.Get("code")]
+	delete(p.grants, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	if !found || time.Now().After(g.expiresAt) || g.redirectURI != r.PostForm.Get("redirect_uri") ||
+		oidc.Challenge(r.PostForm.Get("code_verifier")) != g.challenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            p.issuer,
+		"sub":            g.subject,
+		"aud":            g.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.email,
+		"email_verified": g.emailVerified,
+	})
+	idToken.Header["kid"] = keyID
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, signing.JWKSet{Keys: []signing.JWK{{
+		KeyType:   "RSA",
+		Use:       "sig",
+		Algorithm: jwt.SigningMethodRS256.Alg(),
+		ID:        keyID,
+		N:         base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+	}}})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logger.Log.Info("Error while sending JSON: " + err.Error())
+	}
+}
+
+func randomString() string {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		logger.Log.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"myLibrary/internal/config"
+	"myLibrary/internal/user"
+	"myLibrary/package/client/mailer"
+	"myLibrary/package/logger"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+type discardMailer struct{}
+
+func (discardMailer) Send(mailer.Message) error { return nil }
+
+// newProvider starts the mock provider, signing users in as email.
+func newProvider(t *testing.T, email string, emailVerified bool) *provider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &provider{
+		clientID:      "mylibrary",
+		clientSecret:  "mock-secret",
+		subject:       "mock-user-1",
+		email:         email,
+		emailVerified: emailVerified,
+		key:           key,
+		grants:        make(map[string]*grant),
+	}
+	server := httptest.NewServer(p.routes())
+	t.Cleanup(server.Close)
+	p.issuer = server.URL
+	return p
+}
+
+// newApp serves the user handler with OIDC login at p.
+func newApp(t *testing.T, p *provider) (*httptest.Server, user.Repositories) {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Key.SecretKey = "test-secret"
+	cfg.Key.AccessTTL = time.Minute
+	cfg.Key.RefreshTTL = time.Hour
+	cfg.OIDC = config.OIDCConfig{
+		Issuer:        p.issuer,
+		ClientID:      p.clientID,
+		ClientSecret:  p.clientSecret,
+		Scopes:        []string{"openid", "email", "profile"},
+		LinkByEmail:   true,
+		AutoProvision: true,
+	}
+
+	router := httprouter.New()
+	app := httptest.NewServer(router)
+	t.Cleanup(app.Close)
+	cfg.PublicURL = app.URL
+	repos := user.NewMemoryRepositories()
+	user.NewHandler(repos, discardMailer{}, cfg).Register(router)
+	return app, repos
+}
+
+// oidcLogin follows the redirects of a browser from /oidc/login through the
+// provider back to the callback and returns its answer.
+func oidcLogin(t *testing.T, app *httptest.Server) (int, string) {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Jar: jar}
+	response, err := client.Get(app.URL + user.OIDCLoginUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(response.Request.URL.String(), app.URL+user.OIDCCallbackUrl) {
+		t.Fatalf("login ended at %s", response.Request.URL)
+	}
+	return response.StatusCode, string(body)
+}
+
+// userOf fetches user userID with accessToken.
+func userOf(t *testing.T, app *httptest.Server, userID, accessToken string) (int, *user.User) {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodGet, app.URL+"/user/"+userID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var found user.User
+	if response.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(response.Body).Decode(&found); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return response.StatusCode, &found
+}
+
+func accessToken(t *testing.T, body string) string {
+	t.Helper()
+	var tokens user.LoginResponse
+	if err := json.Unmarshal([]byte(body), &tokens); err != nil || tokens.AccessToken == "" {
+		t.Fatalf("no tokens in %s", body)
+	}
+	return tokens.AccessToken
+}
+
+func TestLoginProvisionsUser(t *testing.T) {
+	p := newProvider(t, "oidc.user@example.com", true)
+	app, _ := newApp(t, p)
+
+	status, body := oidcLogin(t, app)
+	if status != http.StatusOK {
+		t.Fatalf("first login: %d %s", status, body)
+	}
+	status, provisioned := userOf(t, app, "1", accessToken(t, body))
+	if status != http.StatusOK || provisioned.Email != p.email || !provisioned.EmailVerified {
+		t.Fatalf("provisioned user: %d %+v", status, provisioned)
+	}
+
+	// The second login finds the linked identity instead of provisioning again.
+	status, body = oidcLogin(t, app)
+	if status != http.StatusOK {
+		t.Fatalf("second login: %d %s", status, body)
+	}
+	if status, _ := userOf(t, app, "1", accessToken(t, body)); status != http.StatusOK {
+		t.Fatalf("second login signed in as someone else: %d", status)
+	}
+}
+
+func TestLoginLinksByEmail(t *testing.T) {
+	p := newProvider(t, "reader@example.com", true)
+	app, repos := newApp(t, p)
+	existing, err := repos.Users.Create(&user.User{Username: "reader", Email: "reader@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, body := oidcLogin(t, app)
+	if status != http.StatusOK {
+		t.Fatalf("login: %d %s", status, body)
+	}
+	if status, _ := userOf(t, app, existing.ID, accessToken(t, body)); status != http.StatusOK {
+		t.Fatalf("not signed in as the existing user: %d", status)
+	}
+}
+
+func TestLoginRefusesUnverifiedEmail(t *testing.T) {
+	p := newProvider(t, "reader@example.com", false)
+	app, repos := newApp(t, p)
+	if _, err := repos.Users.Create(&user.User{Username: "reader", Email: "reader@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, body := oidcLogin(t, app); status != http.StatusForbidden {
+		t.Fatalf("got %d %s, want 403", status, body)
+	}
+}
@@ -4,40 +4,152 @@ import (
 	"github.com/ilyakaznacheev/cleanenv"
 	"myLibrary/package/logger"
 	"sync"
+	"time"
 )
 
 type Config struct {
-	IsDebug *bool         `yaml:"is_debug" env-required:"true"`
-	Listen  Listener      `yaml:"listen"`
-	Storage StorageConfig `yaml:"storage"`
-	Key     JWTSecretKey  `yaml:"authorization"`
+	IsDebug   *bool         `yaml:"is_debug" env-required:"true"`
+	Listen    Listener      `yaml:"listen"`
+	Storage   StorageConfig `yaml:"storage"`
+	Key       JWTSecretKey  `yaml:"authorization"`
+	Mail      MailConfig    `yaml:"mail"`
+	Login     LoginLimits   `yaml:"login"`
+	RateLimit RateLimits    `yaml:"rate_limit"`
+	OIDC      OIDCConfig    `yaml:"oidc"`
+	// PublicURL is the address of the API as users see it, used in emailed links.
+	PublicURL string `yaml:"public_url" env-default:"http://localhost:10000"`
+	// PasswordResetURL is the front-end page that asks for a new password.
+	// The reset email links there with ?token=; without it the email only
+	// carries the token.
+	PasswordResetURL string `yaml:"password_reset_url"`
 }
 
 type Listener struct {
-	Type   string `yaml:"type"`
-	BindIp string `yaml:"bind_ip"`
-	Port   string `yaml:"port"`
+	Type            string        `yaml:"type"`
+	BindIp          string        `yaml:"bind_ip"`
+	Port            string        `yaml:"port"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env-default:"20s"`
+	// TrustedProxies lists addresses or CIDR ranges of reverse proxies whose
+	// X-Forwarded-For header names the real client.
+	TrustedProxies []string `yaml:"trusted_proxies"`
 }
 
 type StorageConfig struct {
+	Host        string `yaml:"host"`
+	Port        rune   `yaml:"port"`
+	Database    string `yaml:"database"`
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	AutoMigrate bool   `yaml:"auto_migrate"`
+}
+
+type JWTSecretKey struct {
+	// SecretKey signs HS256 tokens while no SigningKeys are configured. After
+	// switching to SigningKeys it only verifies tokens that are still valid.
+	SecretKey   string        `yaml:"key"`
+	SigningKeys []SigningKey  `yaml:"signing_keys"`
+	AccessTTL   time.Duration `yaml:"access_ttl" env-default:"15m"`
+	RefreshTTL  time.Duration `yaml:"refresh_ttl" env-default:"720h"`
+	ResetTTL    time.Duration `yaml:"reset_ttl" env-default:"1h"`
+	VerifyTTL   time.Duration `yaml:"verify_ttl" env-default:"48h"`
+}
+
+// SigningKey is one asymmetric key of the rotation schedule. Tokens are signed
+// with the key that became active last; a key is replaced once the next one's
+// ActiveFrom has passed, and keeps verifying until its tokens have expired.
+type SigningKey struct {
+	ID string `yaml:"kid"`
+	// Algorithm is RS256 or EdDSA (Ed25519).
+	Algorithm string `yaml:"algorithm"`
+	// PrivateKeyFile is a PEM file with a PKCS#8 (or PKCS#1 for RSA) private key.
+	PrivateKeyFile string    `yaml:"private_key_file"`
+	ActiveFrom     time.Time `yaml:"active_from"`
+}
+
+type MailConfig struct {
+	Driver   string `yaml:"driver" env-default:"log"`
 	Host     string `yaml:"host"`
-	Port     rune   `yaml:"port"`
-	Database string `yaml:"database"`
+	Port     int    `yaml:"port" env-default:"587"`
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
+	From     string `yaml:"from" env-default:"myLibrary <no-reply@localhost>"`
+	LogFile  string `yaml:"log_file"`
 }
 
-type JWTSecretKey struct {
-	SecretKey string `yaml:"key"`
+// LoginLimits configures how failed logins and 2FA codes are throttled. Zero
+// MaxFailures or IPMaxFailures turns that lockout off, zero BackoffBase the
+// backoff.
+type LoginLimits struct {
+	MaxFailures   int           `yaml:"max_failures"`
+	Lockout       time.Duration `yaml:"lockout"`
+	BackoffBase   time.Duration `yaml:"backoff_base"`
+	IPMaxFailures int           `yaml:"ip_max_failures"`
+	IPLockout     time.Duration `yaml:"ip_lockout"`
+}
+
+// RateLimits holds one token bucket configuration per route group. Buckets
+// are kept per authenticated user, or per client IP for anonymous requests.
+type RateLimits struct {
+	// Auth covers registration, login and the other unauthenticated account endpoints.
+	Auth  RateLimit `yaml:"auth"`
+	Read  RateLimit `yaml:"read"`
+	Write RateLimit `yaml:"write"`
+	Admin RateLimit `yaml:"admin"`
+}
+
+// RateLimit allows Requests per Per on average and bursts of up to Burst
+// requests. Zero Requests turns the limit off; groups missing from the file
+// allow 60 requests.
+type RateLimit struct {
+	Requests int           `yaml:"requests"`
+	Per      time.Duration `yaml:"per" env-default:"1m"`
+	Burst    int           `yaml:"burst"`
+}
+
+// OIDCConfig enables login through an external OpenID Connect provider. It
+// is off while Issuer is empty. The provider has to allow the redirect URI
+// public_url + /oidc/callback.
+type OIDCConfig struct {
+	Issuer       string   `yaml:"issuer"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes" env-default:"openid,email,profile"`
+	// LinkByEmail signs a provider account into the existing user with the
+	// same email, provided the provider has verified that email. On by default.
+	LinkByEmail bool `yaml:"link_by_email"`
+	// AutoProvision creates a user on the first login of an unknown provider
+	// account. On by default.
+	AutoProvision bool `yaml:"auto_provision"`
 }
 
 var instance *Config
 var once sync.Once
 
+// defaults returns the settings whose default is not the zero value. cleanenv
+// applies env-default to every field that is zero after reading the file, so
+// such defaults would override an explicit false or 0; these are set before
+// the file is read instead.
+func defaults() *Config {
+	rateLimit := RateLimit{Requests: 60}
+	login := LoginLimits{
+		MaxFailures:   5,
+		Lockout:       15 * time.Minute,
+		BackoffBase:   time.Second,
+		IPMaxFailures: 50,
+		IPLockout:     15 * time.Minute,
+	}
+	return &Config{
+		Storage:   StorageConfig{AutoMigrate: true},
+		Login:     login,
+		RateLimit: RateLimits{Auth: rateLimit, Read: rateLimit, Write: rateLimit, Admin: rateLimit},
+		OIDC:      OIDCConfig{LinkByEmail: true, AutoProvision: true},
+	}
+}
+
 func GetConfig() *Config {
 	once.Do(func() {
 		logger.Log.Info("Reading app configuration")
-		instance = &Config{}
+		instance = defaults()
 		if err := cleanenv.ReadConfig("config.yml", instance); err != nil {
 			help, _ := cleanenv.GetDescription(instance, nil)
 			logger.Log.Error(help)
@@ -0,0 +1,301 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"io"
+	"myLibrary/internal/config"
+	"myLibrary/internal/signing"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnavailable wraps failures to reach the provider, as opposed to the
+// provider rejecting the login.
+var ErrUnavailable = errors.New("OIDC provider unavailable")
+
+// jwksRefreshInterval limits refetching the provider keys when a token names
+// an unknown kid, so forged tokens cannot make us hammer the provider.
+const jwksRefreshInterval = time.Minute
+
+// Provider runs the authorization code flow with PKCE against one OpenID
+// Connect provider. The discovery document and signing keys are fetched on
+// first use and cached.
+type Provider struct {
+	cfg         config.OIDCConfig
+	redirectURL string
+	client      *http.Client
+
+	mu          sync.Mutex
+	discovery   *discovery
+	keys        map[string]interface{}
+	keysFetched time.Time
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Identity is what a verified ID token says about the user.
+type Identity struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+// New returns nil when cfg has no issuer, meaning OIDC login is off.
+func New(cfg config.OIDCConfig, redirectURL string) *Provider {
+	if cfg.Issuer == "" {
+		return nil
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	return &Provider{
+		cfg:         cfg,
+		redirectURL: redirectURL,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Challenge is the S256 PKCE code challenge of verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL is where the user is sent to sign in at the provider.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	d, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.redirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return d.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems the authorization code and returns the raw ID token.
+func (p *Provider) Exchange(code, verifier string) (string, error) {
+	d, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {verifier},
+	}
+	request, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	if response.StatusCode >= 500 {
+		return "", fmt.Errorf("%w: token endpoint answered %d", ErrUnavailable, response.StatusCode)
+	}
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return "", fmt.Errorf("token endpoint answered %d with invalid JSON", response.StatusCode)
+	}
+	if response.StatusCode != http.StatusOK || tokens.Error != "" {
+		return "", fmt.Errorf("code exchange failed: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return "", errors.New("token endpoint returned no id_token")
+	}
+	return tokens.IDToken, nil
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of
+// an ID token (OpenID Connect Core 3.1.3.7).
+func (p *Provider) VerifyIDToken(rawIDToken, nonce string) (*Identity, error) {
+	parser := &jwt.Parser{ValidMethods: []string{
+		jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg(), signing.SigningMethodEdDSA.Alg(),
+	}}
+	token, err := parser.Parse(rawIDToken, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(kid)
+	})
+	if err != nil {
+		// jwt-go v3 errors do not unwrap, the key lookup error is in Inner.
+		if validationErr, ok := err.(*jwt.ValidationError); ok && errors.Is(validationErr.Inner, ErrUnavailable) {
+			return nil, validationErr.Inner
+		}
+		return nil, fmt.Errorf("invalid ID token: %v", err)
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid ID token")
+	}
+
+	if issuer, _ := claims["iss"].(string); issuer != p.cfg.Issuer {
+		return nil, errors.New("ID token has a wrong issuer")
+	}
+	if !p.forThisClient(claims) {
+		return nil, errors.New("ID token is not for this client")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("ID token has no expiry")
+	}
+	if claims["nonce"] != nonce {
+		return nil, errors.New("ID token has a wrong nonce")
+	}
+
+	identity := &Identity{Issuer: p.cfg.Issuer}
+	identity.Subject, _ = claims["sub"].(string)
+	if identity.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	identity.Email, _ = claims["email"].(string)
+	identity.PreferredUsername, _ = claims["preferred_username"].(string)
+	identity.Name, _ = claims["name"].(string)
+	// Some providers send email_verified as a string.
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+	return identity, nil
+}
+
+// forThisClient checks aud, which may be a string or a list, and azp when
+// there are several audiences. jwt-go v3 only understands a string aud.
+func (p *Provider) forThisClient(claims jwt.MapClaims) bool {
+	switch audience := claims["aud"].(type) {
+	case string:
+		return audience == p.cfg.ClientID
+	case []interface{}:
+		found := false
+		for _, entry := range audience {
+			if entry == p.cfg.ClientID {
+				found = true
+			}
+		}
+		return found && (len(audience) == 1 || claims["azp"] == p.cfg.ClientID)
+	default:
+		return false
+	}
+}
+
+func (p *Provider) discover() (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var d discovery
+	if err := p.getJSON(p.cfg.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	if d.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("%w: discovery names issuer %q", ErrUnavailable, d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: discovery document is incomplete", ErrUnavailable)
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// key returns the provider key kid, refetching the key set when kid is new
+// to us, since the provider may have rotated its keys.
+func (p *Provider) key(kid string) (interface{}, error) {
+	d, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < jwksRefreshInterval {
+		return nil, errors.New("unknown signing key")
+	}
+
+	var set signing.JWKSet
+	if err := p.getJSON(d.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	p.keysFetched = time.Now()
+	p.keys = make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// Keys of unsupported types are skipped rather than failing the set.
+		if key, err := jwk.PublicKey(); err == nil {
+			p.keys[jwk.ID] = key
+		}
+	}
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errors.New("unknown signing key")
+}
+
+func (p *Provider) getJSON(target string, value interface{}) error {
+	response, err := p.client.Get(target)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s answered %d", ErrUnavailable, target, response.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(value); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrUnavailable, target, err)
+	}
+	return nil
+}
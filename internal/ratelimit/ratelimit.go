@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter is a set of token buckets, one per key. Each bucket holds up to
+// Burst tokens and regains Requests tokens every Per; a request takes one.
+type Limiter struct {
+	burst float64
+	rate  float64 // tokens per second
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Decision is the outcome of Allow together with the numbers for RateLimit-* headers.
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed; zero if Allowed.
+	RetryAfter time.Duration
+}
+
+// New returns a limiter, or nil when requests or per is not positive. A nil
+// Limiter allows everything.
+func New(requests int, per time.Duration, burst int) *Limiter {
+	if requests <= 0 || per <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = requests
+	}
+	return &Limiter{
+		burst:   float64(burst),
+		rate:    float64(requests) / per.Seconds(),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (l *Limiter) Allow(key string, now time.Time) Decision {
+	if l == nil {
+		return Decision{Allowed: true}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+		b.last = now
+	}
+
+	decision := Decision{Limit: int(l.burst)}
+	if b.tokens >= 1 {
+		b.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = l.duration(1 - b.tokens)
+	}
+	decision.Remaining = int(b.tokens)
+	decision.Reset = l.duration(l.burst - b.tokens)
+	return decision
+}
+
+func (l *Limiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// sweep drops buckets that have refilled completely, since a new bucket is
+// the same as a full one. Callers hold mu.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterBurstAndRefill(t *testing.T) {
+	// 60 per minute is one token a second, with bursts of 3.
+	limiter := New(60, time.Minute, 3)
+	now := time.Unix(1700000000, 0)
+
+	for i := 0; i < 3; i++ {
+		if decision := limiter.Allow("a", now); !decision.Allowed || decision.Remaining != 2-i {
+			t.Fatalf("request %d of the burst: %+v", i+1, decision)
+		}
+	}
+	decision := limiter.Allow("a", now)
+	if decision.Allowed || decision.RetryAfter != time.Second || decision.Reset != 3*time.Second {
+		t.Fatalf("after the burst: %+v", decision)
+	}
+	if decision := limiter.Allow("b", now); !decision.Allowed {
+		t.Errorf("another key shares the bucket: %+v", decision)
+	}
+
+	now = now.Add(time.Second)
+	if decision := limiter.Allow("a", now); !decision.Allowed || decision.Remaining != 0 {
+		t.Errorf("a second later: %+v", decision)
+	}
+	if decision := limiter.Allow("a", now); decision.Allowed {
+		t.Errorf("the refilled token was used twice: %+v", decision)
+	}
+
+	// Refilling stops at the burst size.
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if decision := limiter.Allow("a", now); !decision.Allowed {
+			t.Fatalf("request %d after an hour: %+v", i+1, decision)
+		}
+	}
+	if decision := limiter.Allow("a", now); decision.Allowed {
+		t.Errorf("more than the burst after an hour: %+v", decision)
+	}
+}
+
+func TestLimiterDefaultsAndOff(t *testing.T) {
+	if limiter := New(0, time.Minute, 10); limiter != nil {
+		t.Error("zero requests built a limiter")
+	}
+	var off *Limiter
+	if decision := off.Allow("a", time.Now()); !decision.Allowed {
+		t.Errorf("nil limiter: %+v", decision)
+	}
+
+	// Without a burst, the whole rate can be used at once.
+	limiter := New(5, time.Minute, 0)
+	now := time.Unix(1700000000, 0)
+	for i := 0; i < 5; i++ {
+		if decision := limiter.Allow("a", now); !decision.Allowed {
+			t.Fatalf("request %d: %+v", i+1, decision)
+		}
+	}
+	if decision := limiter.Allow("a", now); decision.Allowed || decision.RetryAfter != 12*time.Second {
+		t.Errorf("sixth request: %+v", decision)
+	}
+}
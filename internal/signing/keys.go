@@ -0,0 +1,184 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"myLibrary/internal/config"
+	"os"
+	"sort"
+	"time"
+)
+
+const minRSABits = 2048
+
+// Key signs and verifies tokens with one algorithm. The legacy HS256 secret is
+// a Key without an ID.
+type Key struct {
+	ID         string
+	Method     jwt.SigningMethod
+	ActiveFrom time.Time
+	// RetiresAt is when the last token the key may have signed expires. It is
+	// zero for the newest key.
+	RetiresAt time.Time
+
+	private interface{}
+	public  interface{}
+}
+
+func (k *Key) verifies(now time.Time) bool {
+	return !k.ActiveFrom.After(now) && (k.RetiresAt.IsZero() || now.Before(k.RetiresAt))
+}
+
+// KeySet follows the rotation schedule of config.JWTSecretKey: it signs with
+// the key that became active last and verifies with every key that may still
+// have unexpired tokens out there.
+type KeySet struct {
+	keys []*Key // ordered by ActiveFrom
+}
+
+// Load reads the keys of cfg. maxTTL is the lifetime of the longest-lived
+// token signed with the set; a replaced key keeps verifying for that long.
+func Load(cfg config.JWTSecretKey, maxTTL time.Duration) (*KeySet, error) {
+	set := &KeySet{}
+	if len(cfg.SigningKeys) == 0 || cfg.SecretKey != "" {
+		secret := []byte(cfg.SecretKey)
+		set.keys = append(set.keys, &Key{Method: jwt.SigningMethodHS256, private: secret, public: secret})
+	}
+
+	seen := make(map[string]bool)
+	for _, keyCfg := range cfg.SigningKeys {
+		if keyCfg.ID == "" {
+			return nil, errors.New("signing key without kid")
+		}
+		if seen[keyCfg.ID] {
+			return nil, fmt.Errorf("duplicate signing key %q", keyCfg.ID)
+		}
+		seen[keyCfg.ID] = true
+
+		key, err := loadKey(keyCfg)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %q: %w", keyCfg.ID, err)
+		}
+		set.keys = append(set.keys, key)
+	}
+
+	sort.SliceStable(set.keys, func(i, j int) bool {
+		return set.keys[i].ActiveFrom.Before(set.keys[j].ActiveFrom)
+	})
+	for i := 0; i < len(set.keys)-1; i++ {
+		set.keys[i].RetiresAt = set.keys[i+1].ActiveFrom.Add(maxTTL)
+	}
+
+	if set.current(time.Now()) == nil {
+		return nil, errors.New("no signing key is active yet")
+	}
+	return set, nil
+}
+
+func loadKey(cfg config.SigningKey) (*Key, error) {
+	raw, err := os.ReadFile(cfg.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	private, err := parsePrivateKey(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: cfg.ID, ActiveFrom: cfg.ActiveFrom, private: private}
+	switch cfg.Algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		rsaKey, ok := private.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("RS256 needs an RSA private key")
+		}
+		if rsaKey.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key must have at least %d bits", minRSABits)
+		}
+		key.Method = jwt.SigningMethodRS256
+		key.public = &rsaKey.PublicKey
+	case SigningMethodEdDSA.Alg():
+		edKey, ok := private.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("EdDSA needs an Ed25519 private key")
+		}
+		key.Method = SigningMethodEdDSA
+		key.public = edKey.Public()
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q, use RS256 or EdDSA", cfg.Algorithm)
+	}
+	return key, nil
+}
+
+func parsePrivateKey(raw []byte) (interface{}, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+	}
+}
+
+// current is the signing key at now.
+func (s *KeySet) current(now time.Time) *Key {
+	var current *Key
+	for _, key := range s.keys {
+		if key.ActiveFrom.After(now) {
+			break
+		}
+		current = key
+	}
+	return current
+}
+
+// Sign signs claims with the current key and names it in the kid header.
+func (s *KeySet) Sign(claims jwt.MapClaims) (string, error) {
+	key := s.current(time.Now())
+	if key == nil {
+		return "", errors.New("no signing key is active")
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.private)
+}
+
+// Parse verifies the signature and expiry of tokenString. The token has to
+// name a key that still verifies and use that key's algorithm.
+func (s *KeySet) Parse(tokenString string) (jwt.MapClaims, error) {
+	now := time.Now()
+	token, err := new(jwt.Parser).Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		for _, key := range s.keys {
+			if key.ID != kid || !key.verifies(now) {
+				continue
+			}
+			if token.Method.Alg() != key.Method.Alg() {
+				return nil, errors.New("unexpected signing method")
+			}
+			return key.public, nil
+		}
+		return nil, errors.New("unknown signing key")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
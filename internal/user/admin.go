@@ -0,0 +1,149 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"myLibrary/package/logger"
+	"net/http"
+)
+
+const (
+	AdminUsersUrl = "/admin/users"
+	UserIdUrl     = "/:uuid"
+	RoleUrl       = "/role"
+	DisableUrl    = "/disable"
+	EnableUrl     = "/enable"
+)
+
+func (h *handler) ListUsers(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	page, err := ParsePage(r.URL.Query(), nil)
+	if err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		logger.Log.Info("Bad request: " + err.Error())
+		return
+	}
+
+	users, err := h.users.List(page)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+
+	summaries := make([]UserSummary, 0, len(users.Users))
+	for _, user := range users.Users {
+		summaries = append(summaries, UserSummary{
+			ID:            user.ID,
+			Username:      user.Username,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+			Role:          user.Role,
+			Disabled:      user.Disabled,
+			TOTPEnabled:   user.TOTPEnabled,
+		})
+	}
+
+	err = WriteList(w, r, summaries, page, users.Next)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while sending JSON: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Error while sending JSON: ") + err.Error())
+		return
+	}
+}
+
+func (h *handler) SetUserRole(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var request SetRoleRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Bad request body: "+err.Error(), http.StatusBadRequest)
+		logger.Log.Info("Bad request body: " + err.Error())
+		return
+	}
+
+	role, err := ParseRole(request.Role)
+	if err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		logger.Log.Info("Bad request: " + err.Error())
+		return
+	}
+
+	if !h.notSelf(w, r, params) {
+		return
+	}
+
+	err = h.users.SetRole(params.ByName("uuid"), role)
+	if err != nil {
+		h.adminUpdateFailed(w, err)
+		return
+	}
+	logger.Log.Info("User " + params.ByName("uuid") + " now has role " + string(role))
+	h.audit(r, AuditEvent{Event: AuditRoleChanged, UserID: params.ByName("uuid"), Details: map[string]string{"role": string(role)}})
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// DisableUser blocks login and ends every session of the user. Their data is kept.
+func (h *handler) DisableUser(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	if !h.notSelf(w, r, params) {
+		return
+	}
+
+	userID := params.ByName("uuid")
+	err := h.users.SetDisabled(userID, true)
+	if err != nil {
+		h.adminUpdateFailed(w, err)
+		return
+	}
+
+	err = h.tokens.RevokeUserFamilies(userID)
+	if err != nil {
+		logger.Log.Error("User disabled, but sessions were not revoked: " + err.Error())
+	}
+	logger.Log.Info("User " + userID + " disabled")
+	h.audit(r, AuditEvent{Event: AuditUserDisabled, UserID: userID})
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *handler) EnableUser(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	err := h.users.SetDisabled(params.ByName("uuid"), false)
+	if err != nil {
+		h.adminUpdateFailed(w, err)
+		return
+	}
+	logger.Log.Info("User " + params.ByName("uuid") + " enabled")
+	h.audit(r, AuditEvent{Event: AuditUserEnabled, UserID: params.ByName("uuid")})
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteOtherUser is DeleteUser for admins, who cannot delete themselves this way.
+func (h *handler) DeleteOtherUser(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	if !h.notSelf(w, r, params) {
+		return
+	}
+	h.DeleteUser(w, r, params)
+}
+
+// notSelf keeps admins from demoting, disabling or deleting themselves, which
+// could leave nobody able to undo it.
+func (h *handler) notSelf(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
+	caller, _ := CallerFromContext(r.Context())
+	if caller.ID == params.ByName("uuid") {
+		http.Error(w, "Bad request: admins cannot change their own role or state", http.StatusBadRequest)
+		logger.Log.Info("Bad request: admin " + caller.ID + " tried to change their own role or state")
+		return false
+	}
+	return true
+}
+
+func (h *handler) adminUpdateFailed(w http.ResponseWriter, err error) {
+	if err == ErrNotFound {
+		http.Error(w, "Bad request: User not found", http.StatusNotFound)
+		logger.Log.Info("Bad request: User not found")
+		return
+	}
+	http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+	logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+}
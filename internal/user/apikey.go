@@ -0,0 +1,129 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"myLibrary/package/logger"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	KeysUrl  = "/keys"
+	KeyIdUrl = "/:keyID"
+)
+
+// apiKeyPrefix marks bearer tokens that are API keys rather than JWTs.
+const apiKeyPrefix = "mlk_"
+
+// apiKeyDisplayLength is how much of the secret part listings show, enough to
+// tell keys apart without weakening them.
+const apiKeyDisplayLength = 8
+
+const maxAPIKeyNameLength = 64
+
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// CreateAPIKey responds with the new key. Only its hash is stored, so this is
+// the one time the key can be read.
+func (h *handler) CreateAPIKey(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var request CreateAPIKeyRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Bad request body: "+err.Error(), http.StatusBadRequest)
+		logger.Log.Info("Bad request body: " + err.Error())
+		return
+	}
+
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || utf8.RuneCountInString(request.Name) > maxAPIKeyNameLength {
+		http.Error(w, fmt.Sprintf("Bad request: name is required and at most %d characters", maxAPIKeyNameLength),
+			http.StatusBadRequest)
+		logger.Log.Info("Bad request: invalid API key name")
+		return
+	}
+
+	scopes, err := ParseScopes(request.Scopes)
+	if err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		logger.Log.Info("Bad request: " + err.Error())
+		return
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		http.Error(w, "Error when generating key: "+err.Error(), http.StatusInternalServerError)
+		logger.Log.Info("Error when generating key: " + err.Error())
+		return
+	}
+	key := apiKeyPrefix + secret
+
+	caller, _ := CallerFromContext(r.Context())
+	created, err := h.apiKeys.Create(caller.ID, hashToken(key), &APIKey{
+		Name:   request.Name,
+		Prefix: key[:len(apiKeyPrefix)+apiKeyDisplayLength],
+		Scopes: scopes,
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+	logger.Log.Info("User " + caller.ID + " created API key " + created.ID)
+	h.audit(r, AuditEvent{
+		Event:   AuditAPIKeyCreated,
+		UserID:  caller.ID,
+		Details: map[string]string{"key_id": created.ID, "scopes": joinScopes(scopes)},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(CreatedAPIKey{APIKey: *created, Key: key})
+	if err != nil {
+		logger.Log.Info(fmt.Sprintf("API key created, but while sending JSON for respond: ") + err.Error())
+		return
+	}
+}
+
+func (h *handler) ListAPIKeys(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	caller, _ := CallerFromContext(r.Context())
+	keys, err := h.apiKeys.List(caller.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(keys)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while sending JSON: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Error while sending JSON: ") + err.Error())
+		return
+	}
+}
+
+// RevokeAPIKey takes effect immediately: the next request with the key is rejected.
+func (h *handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	caller, _ := CallerFromContext(r.Context())
+	err := h.apiKeys.Revoke(caller.ID, params.ByName("keyID"))
+	if err != nil {
+		if err == ErrNotFound {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			logger.Log.Info("API key " + params.ByName("keyID") + " not found")
+			return
+		}
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+	logger.Log.Info("User " + caller.ID + " revoked API key " + params.ByName("keyID"))
+	h.audit(r, AuditEvent{Event: AuditAPIKeyRevoked, UserID: caller.ID, Details: map[string]string{"key_id": params.ByName("keyID")}})
+
+	w.WriteHeader(http.StatusOK)
+}
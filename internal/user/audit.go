@@ -0,0 +1,116 @@
+package user
+
+import (
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"myLibrary/package/logger"
+	"net/http"
+)
+
+const (
+	AuditUrl      = "/audit"
+	AdminAuditUrl = "/admin/audit"
+)
+
+// Events recorded in the audit log.
+const (
+	AuditLoginSucceeded    = "login.succeeded"
+	AuditLoginFailed       = "login.failed"
+	AuditTokenRefreshed    = "token.refreshed"
+	AuditTokenReused       = "token.reused"
+	AuditLogoutAll         = "logout.all"
+	AuditSessionRevoked    = "session.revoked"
+	AuditPasswordChanged   = "password.changed"
+	AuditEmailChanged      = "email.changed"
+	AuditUserDeleted       = "user.deleted"
+	AuditTwoFactorEnabled  = "2fa.enabled"
+	AuditTwoFactorDisabled = "2fa.disabled"
+	AuditAPIKeyCreated     = "apikey.created"
+	AuditAPIKeyRevoked     = "apikey.revoked"
+	AuditRoleChanged       = "admin.role_changed"
+	AuditUserDisabled      = "admin.user_disabled"
+	AuditUserEnabled       = "admin.user_enabled"
+	AuditBookDeleted       = "admin.book_deleted"
+)
+
+// Login methods recorded with AuditLoginSucceeded. A second factor is
+// appended, as in "password+totp".
+const (
+	loginMethodPassword = "password"
+	loginMethodOIDC     = "oidc"
+)
+
+// The audit log is listed newest first.
+var (
+	auditSortKeys    = []SortKey{{Field: "id", Desc: true}}
+	auditSortColumns = map[string]string{"id": "id"}
+)
+
+// audit appends event with the client address and user agent of r. The actor
+// defaults to the authenticated caller. A failed write is logged but does not
+// fail the request, which has already taken effect.
+func (h *handler) audit(r *http.Request, event AuditEvent) {
+	if caller, ok := CallerFromContext(r.Context()); ok && event.ActorID == "" {
+		event.ActorID = caller.ID
+		if caller.APIKeyID != "" {
+			if event.Details == nil {
+				event.Details = make(map[string]string)
+			}
+			event.Details["api_key_id"] = caller.APIKeyID
+		}
+	}
+	event.IP = h.proxies.ClientIP(r)
+	event.UserAgent = userAgent(r)
+
+	if err := h.audits.Append(&event); err != nil {
+		logger.Log.Error("Could not write audit event " + event.Event + ": " + err.Error())
+	}
+}
+
+// auditFailedLogin records a rejected login. user is nil when email is not
+// registered; the email is recorded as typed either way.
+func (h *handler) auditFailedLogin(r *http.Request, user *User, email, reason string) {
+	event := AuditEvent{
+		Event:   AuditLoginFailed,
+		Details: map[string]string{"email": truncateBytes(email, 64), "reason": reason},
+	}
+	if user != nil {
+		event.UserID = user.ID
+	}
+	h.audit(r, event)
+}
+
+// GetUserAudit lists the audit log of the path user's own account.
+func (h *handler) GetUserAudit(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	h.listAudit(w, r, AuditQuery{UserID: params.ByName("uuid"), Event: r.URL.Query().Get("event")})
+}
+
+// ListAudit lists the whole audit log, optionally filtered by ?user_id=,
+// ?actor_id= and ?event=.
+func (h *handler) ListAudit(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	query := r.URL.Query()
+	h.listAudit(w, r, AuditQuery{UserID: query.Get("user_id"), ActorID: query.Get("actor_id"), Event: query.Get("event")})
+}
+
+func (h *handler) listAudit(w http.ResponseWriter, r *http.Request, query AuditQuery) {
+	page, err := ParsePage(r.URL.Query(), auditSortKeys)
+	if err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		logger.Log.Info("Bad request: " + err.Error())
+		return
+	}
+
+	events, err := h.audits.List(query, page)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+
+	err = WriteList(w, r, events.Events, page, events.Next)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while sending JSON: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Error while sending JSON: ") + err.Error())
+		return
+	}
+}
@@ -0,0 +1,110 @@
+package user
+
+import (
+	"fmt"
+	"myLibrary/package/client/mailer"
+	"myLibrary/package/logger"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// reauthWindow is how long after logging in a session may change the email or
+// password without repeating the current password. Users without a password
+// of their own, such as those created by OIDC login, log in again instead.
+const reauthWindow = 5 * time.Minute
+
+// confirmSensitiveChange checks that a change of the email or password of
+// stored comes from its owner rather than from a stolen token: the request has
+// to carry the current password, or its session has to be fresh. Wrong
+// passwords count as failed logins of the account.
+func (h *handler) confirmSensitiveChange(w http.ResponseWriter, r *http.Request, stored *User, currentPassword string) bool {
+	if currentPassword == "" {
+		fresh, err := h.isFreshSession(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+			logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+			return false
+		}
+		if !fresh {
+			http.Error(w, "Forbidden: current_password is required to change the email or password", http.StatusForbidden)
+			logger.Log.Info("Forbidden: unconfirmed change of email or password of user " + stored.ID)
+			return false
+		}
+		return true
+	}
+
+	now := time.Now()
+	accountKey := accountLimitKey(stored.Email)
+	if wait := h.limiter.retryAfter(now, accountKey); wait > 0 {
+		tooManyAttempts(w, wait)
+		logger.Log.Info("Password confirmation throttled for user " + stored.ID)
+		return false
+	}
+	if ok, _ := VerifyPassword(stored.Password, currentPassword); !ok {
+		h.limiter.failAccount(now, accountKey)
+		http.Error(w, "Forbidden: current password is wrong", http.StatusForbidden)
+		logger.Log.Info("Forbidden: wrong current password of user " + stored.ID)
+		h.auditFailedLogin(r, stored, stored.Email, "current_password")
+		return false
+	}
+	h.limiter.reset(accountKey)
+	return true
+}
+
+// isFreshSession reports whether the caller logged in within reauthWindow.
+// API keys never count as fresh.
+func (h *handler) isFreshSession(r *http.Request) (bool, error) {
+	caller, _ := CallerFromContext(r.Context())
+	if caller.FamilyID == "" {
+		return false, nil
+	}
+	sessions, err := h.tokens.Sessions(caller.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, session := range sessions {
+		if session.ID == caller.FamilyID {
+			return time.Since(session.CreatedAt) < reauthWindow, nil
+		}
+	}
+	return false, nil
+}
+
+// endOtherSessions logs the user out everywhere but the session of r and
+// revokes their API keys, so a new password locks out whoever knew the old one.
+func (h *handler) endOtherSessions(r *http.Request, userID string) {
+	caller, _ := CallerFromContext(r.Context())
+	err := h.tokens.RevokeOtherFamilies(userID, caller.FamilyID)
+	if err != nil {
+		logger.Log.Error("Password changed, but other sessions were not revoked: " + err.Error())
+	}
+	err = h.apiKeys.RevokeUserKeys(userID)
+	if err != nil {
+		logger.Log.Error("Password changed, but API keys were not revoked: " + err.Error())
+	}
+}
+
+// notifySensitiveChange tells the address the account had before the update
+// about a new password or email, so a takeover does not go unnoticed.
+func (h *handler) notifySensitiveChange(before, after *User, passwordChanged bool) {
+	var changes []string
+	if passwordChanged {
+		changes = append(changes, "- the password was changed")
+	}
+	if before.Email != after.Email {
+		changes = append(changes, "- the email was changed to "+after.Email)
+	}
+
+	err := h.mail.Send(mailer.Message{
+		To:      before.Email,
+		Subject: "Your account was changed",
+		Body: fmt.Sprintf("Hello, %s!\n\nYour myLibrary account was changed:\n\n%s\n\n"+
+			"If it was not you, someone else has access to your account: reset your password "+
+			"and end the sessions you do not recognise.",
+			before.Username, strings.Join(changes, "\n")),
+	})
+	if err != nil {
+		logger.Log.Error("Account changed, but the notification was not sent: " + err.Error())
+	}
+}
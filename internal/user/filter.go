@@ -0,0 +1,141 @@
+package user
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const filterDateLayout = "2006-01-02"
+
+// BookFilter narrows a book query. Zero values mean "no restriction".
+type BookFilter struct {
+	Author    string
+	Title     string
+	RatingMin *int
+	RatingMax *int
+	AddedFrom *time.Time
+	AddedTo   *time.Time
+	Statuses  []BookStatus
+}
+
+// ParseBookFilter reads ?author=&title=&rating_min=&rating_max=&added_from=&added_to=&read=&status=.
+// author and title match case-insensitive substrings, dates are inclusive YYYY-MM-DD.
+// status is a comma separated list of statuses; read=true is short for
+// status=finished and read=false for every other status.
+func ParseBookFilter(query url.Values) (BookFilter, error) {
+	filter := BookFilter{
+		Author: strings.TrimSpace(query.Get("author")),
+		Title:  strings.TrimSpace(query.Get("title")),
+	}
+
+	var err error
+	if filter.RatingMin, err = parseRating(query, "rating_min"); err != nil {
+		return filter, err
+	}
+	if filter.RatingMax, err = parseRating(query, "rating_max"); err != nil {
+		return filter, err
+	}
+	if filter.AddedFrom, err = parseDate(query, "added_from"); err != nil {
+		return filter, err
+	}
+	if filter.AddedTo, err = parseDate(query, "added_to"); err != nil {
+		return filter, err
+	}
+
+	if raw := query.Get("read"); raw != "" {
+		if query.Get("status") != "" {
+			return filter, fmt.Errorf("read and status cannot be combined")
+		}
+		isRead, err := strconv.ParseBool(raw)
+		if err != nil {
+			return filter, fmt.Errorf("read must be true or false")
+		}
+		if isRead {
+			filter.Statuses = []BookStatus{StatusFinished}
+		} else {
+			filter.Statuses = []BookStatus{StatusWantToRead, StatusReading, StatusAbandoned}
+		}
+	}
+	if raw := query.Get("status"); raw != "" {
+		for _, part := range strings.Split(raw, ",") {
+			status, err := ParseBookStatus(strings.TrimSpace(part))
+			if err != nil {
+				return filter, err
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+	return filter, nil
+}
+
+// Where returns the filter as " AND ..." conditions with placeholders numbered after args.
+func (f BookFilter) Where(args []interface{}) (string, []interface{}) {
+	var conditions []string
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, "$"+strconv.Itoa(len(args))))
+	}
+
+	if f.Author != "" {
+		add("author ILIKE %s", "%"+escapeLike(f.Author)+"%")
+	}
+	if f.Title != "" {
+		add("title ILIKE %s", "%"+escapeLike(f.Title)+"%")
+	}
+	if f.RatingMin != nil {
+		add("rating >= %s", *f.RatingMin)
+	}
+	if f.RatingMax != nil {
+		add("rating <= %s", *f.RatingMax)
+	}
+	if f.AddedFrom != nil {
+		add("date_added >= %s", f.AddedFrom.Format(filterDateLayout))
+	}
+	if f.AddedTo != nil {
+		add("date_added < %s", f.AddedTo.AddDate(0, 0, 1).Format(filterDateLayout))
+	}
+	if len(f.Statuses) > 0 {
+		placeholders := make([]string, 0, len(f.Statuses))
+		for _, status := range f.Statuses {
+			args = append(args, string(status))
+			placeholders = append(placeholders, "$"+strconv.Itoa(len(args)))
+		}
+		conditions = append(conditions, "status IN ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " AND " + strings.Join(conditions, " AND "), args
+}
+
+func parseRating(query url.Values, name string) (*int, error) {
+	raw := query.Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	rating, err := strconv.Atoi(raw)
+	if err != nil || rating < 1 || rating > 10 {
+		return nil, fmt.Errorf("%s must be between 1 and 10", name)
+	}
+	return &rating, nil
+}
+
+func parseDate(query url.Values, name string) (*time.Time, error) {
+	raw := query.Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	date, err := time.Parse(filterDateLayout, raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a date in YYYY-MM-DD format", name)
+	}
+	return &date, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
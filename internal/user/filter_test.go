@@ -0,0 +1,68 @@
+package user
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseBookFilter(t *testing.T) {
+	query := url.Values{
+		"author":     {" Herbert "},
+		"rating_min": {"7"},
+		"added_from": {"2024-01-01"},
+		"added_to":   {"2024-12-31"},
+		"status":     {"reading, finished"},
+	}
+	filter, err := ParseBookFilter(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.Author != "Herbert" || filter.Title != "" || *filter.RatingMin != 7 || filter.RatingMax != nil ||
+		filter.AddedFrom.Format(filterDateLayout) != "2024-01-01" || filter.AddedTo.Format(filterDateLayout) != "2024-12-31" ||
+		!reflect.DeepEqual(filter.Statuses, []BookStatus{StatusReading, StatusFinished}) {
+		t.Errorf("filter %+v", filter)
+	}
+
+	for raw, want := range map[string][]BookStatus{
+		"true":  {StatusFinished},
+		"false": {StatusWantToRead, StatusReading, StatusAbandoned},
+	} {
+		filter, err := ParseBookFilter(url.Values{"read": {raw}})
+		if err != nil || !reflect.DeepEqual(filter.Statuses, want) {
+			t.Errorf("read=%s: %v, %v", raw, filter.Statuses, err)
+		}
+	}
+
+	for _, query := range []url.Values{
+		{"rating_min": {"0"}},
+		{"rating_max": {"11"}},
+		{"added_from": {"01.01.2024"}},
+		{"read": {"maybe"}},
+		{"read": {"true"}, "status": {"reading"}},
+		{"status": {"lost"}},
+	} {
+		if filter, err := ParseBookFilter(query); err == nil {
+			t.Errorf("ParseBookFilter(%v) = %+v, want an error", query, filter)
+		}
+	}
+}
+
+func TestBookFilterWhere(t *testing.T) {
+	filter, err := ParseBookFilter(url.Values{"title": {"100%_done"}, "rating_max": {"5"}, "added_to": {"2024-12-31"}, "read": {"true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conditions, args := filter.Where([]interface{}{"user"})
+	want := " AND title ILIKE $2 AND rating <= $3 AND date_added < $4 AND status IN ($5)"
+	if conditions != want {
+		t.Errorf("conditions %q, want %q", conditions, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"user", `%100\%\_done%`, 5, "2025-01-01", "finished"}) {
+		t.Errorf("args %v", args)
+	}
+
+	if conditions, args := (BookFilter{}).Where(nil); conditions != "" || len(args) != 0 {
+		t.Errorf("empty filter: %q %v", conditions, args)
+	}
+}
@@ -1,13 +1,15 @@
 package user
 
 import (
-	"database/sql"
 	"encoding/json"
 	"fmt"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/julienschmidt/httprouter"
 	"myLibrary/internal/config"
 	"myLibrary/internal/handlers"
+	"myLibrary/internal/oidc"
+	"myLibrary/internal/ratelimit"
+	"myLibrary/internal/signing"
+	"myLibrary/package/client/mailer"
 	"myLibrary/package/logger"
 	"net/http"
 	"strconv"
@@ -21,67 +23,287 @@ const (
 	BooksUrl         = "/books"
 	FinishedBooksUrl = "/finished"
 	WishlistBooksUrl = "/wishlist"
+	BookIdUrl        = "/:bookID"
+	SearchBooksUrl   = "/search"
 )
 
 type handler struct {
-	db  *sql.DB
-	cfg *config.Config
+	users    UserRepository
+	books    BookRepository
+	tokens   TokenRepository
+	apiKeys  APIKeyRepository
+	audits   AuditRepository
+	readings ReadingSessionRepository
+	mail     mailer.Mailer
+	limiter  *loginLimiter
+	limits   rateLimits
+	proxies  ratelimit.Proxies
+	signer   *signing.KeySet
+	oidc     *oidc.Provider
+	cfg      *config.Config
 }
 
-func NewHandler(db *sql.DB, cfg *config.Config) handlers.Handler {
-	return &handler{db, cfg}
+func NewHandler(repositories Repositories, mail mailer.Mailer, cfg *config.Config) handlers.Handler {
+	proxies, err := ratelimit.ParseProxies(cfg.Listen.TrustedProxies)
+	if err != nil {
+		logger.Log.Fatal(err)
+	}
+	signer, err := signing.Load(cfg.Key, longestTokenTTL(cfg.Key))
+	if err != nil {
+		logger.Log.Fatal(err)
+	}
+	return &handler{
+		users:    repositories.Users,
+		books:    repositories.Books,
+		tokens:   repositories.Tokens,
+		apiKeys:  repositories.APIKeys,
+		audits:   repositories.Audit,
+		readings: repositories.ReadingSessions,
+		mail:     mail,
+		limiter:  newLoginLimiter(cfg.Login),
+		limits:   newRateLimits(cfg.RateLimit),
+		proxies:  proxies,
+		signer:   signer,
+		oidc:     oidc.New(cfg.OIDC, cfg.PublicURL+OIDCCallbackUrl),
+		cfg:      cfg,
+	}
 }
 
 func (h *handler) Register(router *httprouter.Router) {
-	router.POST(RegisterUrl, h.RegisterUser)
-	router.POST(LoginUrl, h.LoginUser)
-	router.GET(UserUuidUrl, h.GetUserByUUID)
-	router.PUT(UserUuidUrl, h.FullyUpdateUser)
-	router.PATCH(UserUuidUrl, h.UpdateUser)
-	router.DELETE(UserUuidUrl, h.DeleteUser)
-	router.POST(UserUuidUrl+BooksUrl+FinishedBooksUrl, h.AddFinishedBook)
-	router.POST(UserUuidUrl+BooksUrl+WishlistBooksUrl, h.AddWishlistBook)
-	router.GET(UserUuidUrl+BooksUrl+FinishedBooksUrl, h.GetFinishedBooks)
-	router.GET(UserUuidUrl+BooksUrl+WishlistBooksUrl, h.GetWishlistBooks)
-	router.PUT(UserUuidUrl+BooksUrl+FinishedBooksUrl, h.FromWishlistToFinished)
+	router.POST(RegisterUrl, h.limit(h.limits.auth, h.RegisterUser))
+	router.POST(LoginUrl, h.limit(h.limits.auth, h.LoginUser))
+	router.GET(OIDCLoginUrl, h.limit(h.limits.auth, h.OIDCLogin))
+	router.GET(OIDCCallbackUrl, h.limit(h.limits.auth, h.OIDCCallback))
+	router.POST(RefreshTokenUrl, h.limit(h.limits.auth, h.RefreshTokens))
+	router.POST(LogoutUrl, h.limit(h.limits.auth, h.Logout))
+	router.GET(JWKSUrl, h.limit(h.limits.auth, h.JWKS))
+	router.POST(LogoutAllUrl, h.limit(h.limits.auth, h.authenticate(h.requireScope(ScopeSession, h.LogoutEverywhere))))
+	router.POST(ForgotPasswordUrl, h.limit(h.limits.auth, h.ForgotPassword))
+	router.POST(ResetPasswordUrl, h.limit(h.limits.auth, h.ResetPassword))
+	router.GET(VerifyEmailUrl, h.limit(h.limits.auth, h.VerifyEmail))
+	router.POST(ResendVerificationUrl, h.limit(h.limits.auth, h.ResendVerification))
+	router.POST(LoginUrl+TwoFactorUrl, h.limit(h.limits.auth, h.LoginSecondFactor))
+	router.POST(UserUuidUrl+TwoFactorUrl+EnrollUrl, h.limit(h.limits.write, h.protect(ScopeSession, h.EnrollTOTP)))
+	router.POST(UserUuidUrl+TwoFactorUrl+ConfirmUrl, h.limit(h.limits.write, h.protect(ScopeSession, h.ConfirmTOTP)))
+	router.DELETE(UserUuidUrl+TwoFactorUrl, h.limit(h.limits.write, h.protect(ScopeSession, h.DisableTOTP)))
+	router.POST(UserUuidUrl+KeysUrl, h.limit(h.limits.write, h.protect(ScopeSession, h.CreateAPIKey)))
+	router.GET(UserUuidUrl+KeysUrl, h.limit(h.limits.read, h.protect(ScopeSession, h.ListAPIKeys)))
+	router.DELETE(UserUuidUrl+KeysUrl+KeyIdUrl, h.limit(h.limits.write, h.protect(ScopeSession, h.RevokeAPIKey)))
+	router.GET(UserUuidUrl+SessionsUrl, h.limit(h.limits.read, h.protect(ScopeSession, h.ListSessions)))
+	router.DELETE(UserUuidUrl+SessionsUrl+SessionIdUrl, h.limit(h.limits.write, h.protect(ScopeSession, h.RevokeSession)))
+	router.GET(UserUuidUrl+AuditUrl, h.limit(h.limits.read, h.protect(ScopeSession, h.GetUserAudit)))
+	router.GET(AdminAuditUrl, h.limit(h.limits.admin, h.require(PermissionReadAudit, h.ListAudit)))
+	router.GET(AdminUsersUrl, h.limit(h.limits.admin, h.require(PermissionListUsers, h.ListUsers)))
+	router.DELETE(AdminUsersUrl+UserIdUrl, h.limit(h.limits.admin, h.require(PermissionManageUsers, h.DeleteOtherUser)))
+	router.PUT(AdminUsersUrl+UserIdUrl+RoleUrl, h.limit(h.limits.admin, h.require(PermissionManageUsers, h.SetUserRole)))
+	router.POST(AdminUsersUrl+UserIdUrl+DisableUrl, h.limit(h.limits.admin, h.require(PermissionManageUsers, h.DisableUser)))
+	router.POST(AdminUsersUrl+UserIdUrl+EnableUrl, h.limit(h.limits.admin, h.require(PermissionManageUsers, h.EnableUser)))
+	router.DELETE(AdminUsersUrl+UserIdUrl+BooksUrl+BookIdUrl, h.limit(h.limits.admin, h.require(PermissionDeleteContent, h.DeleteBook)))
+	router.GET(UserUuidUrl, h.limit(h.limits.read, h.protect(ScopeUserRead, h.GetUserByUUID)))
+	router.PUT(UserUuidUrl, h.limit(h.limits.write, h.protect(ScopeUserWrite, h.FullyUpdateUser)))
+	router.PATCH(UserUuidUrl, h.limit(h.limits.write, h.protect(ScopeUserWrite, h.UpdateUser)))
+	router.DELETE(UserUuidUrl, h.limit(h.limits.write, h.protect(ScopeSession, h.DeleteUser)))
+	router.POST(UserUuidUrl+BooksUrl+BookIdUrl, h.limit(h.limits.write, h.protect(ScopeBooksWrite, h.postBooksDispatch)))
+	router.POST(UserUuidUrl+BooksUrl+BookIdUrl+StartUrl, h.limit(h.limits.write, h.protect(ScopeBooksWrite, h.StartBook)))
+	router.POST(UserUuidUrl+BooksUrl+BookIdUrl+FinishUrl, h.limit(h.limits.write, h.protect(ScopeBooksWrite, h.FinishBook)))
+	router.POST(UserUuidUrl+BooksUrl+BookIdUrl+AbandonUrl, h.limit(h.limits.write, h.protect(ScopeBooksWrite, h.AbandonBook)))
+	router.POST(UserUuidUrl+BooksUrl+BookIdUrl+ProgressUrl, h.limit(h.limits.write, h.protect(ScopeBooksWrite, h.UpdateProgress)))
+	router.POST(UserUuidUrl+BooksUrl+BookIdUrl+ReadingSessionsUrl, h.limit(h.limits.write, h.protect(ScopeBooksWrite, h.AddReadingSession)))
+	router.GET(UserUuidUrl+BooksUrl+BookIdUrl+ReadingSessionsUrl, h.limit(h.limits.read, h.protect(ScopeBooksRead, h.ListReadingSessions)))
+	router.GET(UserUuidUrl+BooksUrl+BookIdUrl, h.limit(h.limits.read, h.protect(ScopeBooksRead, h.getBooksDispatch)))
+	router.PATCH(UserUuidUrl+BooksUrl+BookIdUrl, h.limit(h.limits.write, h.protect(ScopeBooksWrite, h.UpdateBook)))
+	router.DELETE(UserUuidUrl+BooksUrl+BookIdUrl, h.limit(h.limits.write, h.protect(ScopeBooksWrite, h.DeleteBook)))
+	router.PUT(UserUuidUrl+BooksUrl+FinishedBooksUrl, h.limit(h.limits.write, h.protect(ScopeBooksWrite, h.FromWishlistToFinished)))
 }
 
-func (h *handler) GetFinishedBooks(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	userID := params.ByName("uuid")
-	rows, err := h.db.Query("SELECT id, title, author, cover_image_url, date_added, rating, comment FROM books WHERE user_id = $1 AND is_read = $2", userID, true)
+// getBooksDispatch serves GET /user/:uuid/books/:bookID. httprouter does not allow
+// a wildcard next to static segments, so the list endpoints are routed from here.
+func (h *handler) getBooksDispatch(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	switch "/" + params.ByName("bookID") {
+	case FinishedBooksUrl:
+		h.GetFinishedBooks(w, r, params)
+	case WishlistBooksUrl:
+		h.GetWishlistBooks(w, r, params)
+	case ReadingBooksUrl:
+		h.GetReadingBooks(w, r, params)
+	case AbandonedBooksUrl:
+		h.GetAbandonedBooks(w, r, params)
+	case SearchBooksUrl:
+		h.SearchBooks(w, r, params)
+	default:
+		h.GetBook(w, r, params)
+	}
+}
+
+// postBooksDispatch serves POST /user/:uuid/books/:bookID for the same reason:
+// the transitions of a book share its wildcard with the lists books are added to.
+func (h *handler) postBooksDispatch(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	switch "/" + params.ByName("bookID") {
+	case FinishedBooksUrl:
+		h.AddFinishedBook(w, r, params)
+	case WishlistBooksUrl:
+		h.AddWishlistBook(w, r, params)
+	case ReadingBooksUrl:
+		h.AddReadingBook(w, r, params)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *handler) GetBook(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	bookID, err := strconv.Atoi(params.ByName("bookID"))
 	if err != nil {
+		http.Error(w, "Bad request: Invalid book ID", http.StatusBadRequest)
+		logger.Log.Info("Bad request: Invalid book ID")
+		return
+	}
+
+	book, err := h.books.ByID(params.ByName("uuid"), bookID)
+	if err == nil {
+		err = h.addEstimates(params.ByName("uuid"), book)
+	}
+	if err != nil {
+		if err == ErrNotFound {
+			http.Error(w, "Bad request: Book not found", http.StatusNotFound)
+			logger.Log.Info("Bad request: Book not found")
+			return
+		}
 		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
 		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
 		return
 	}
 
-	defer func(rows *sql.Rows) {
-		err := rows.Close()
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
-			logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(book)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while sending JSON: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Error while sending JSON: ") + err.Error())
+		return
+	}
+}
+
+func (h *handler) UpdateBook(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	bookID, err := strconv.Atoi(params.ByName("bookID"))
+	if err != nil {
+		http.Error(w, "Bad request: Invalid book ID", http.StatusBadRequest)
+		logger.Log.Info("Bad request: Invalid book ID")
+		return
+	}
+
+	var patch BookPatch
+	err = json.NewDecoder(r.Body).Decode(&patch)
+	if err != nil {
+		http.Error(w, "Bad request body: "+err.Error(), http.StatusBadRequest)
+		logger.Log.Info(fmt.Sprintf("Bad request body: ") + err.Error())
+		return
+	}
+
+	if !BookPatchSuitableForRestrictions(&patch) {
+		http.Error(w, "Bad request: Invalid title/author/cover image/rating/page count", http.StatusBadRequest)
+		logger.Log.Info("Bad request: Invalid title/author/cover image/rating/page count")
+		return
+	}
+
+	userID := params.ByName("uuid")
+	book, err := h.books.ByID(userID, bookID)
+	if err != nil {
+		if err == ErrNotFound {
+			http.Error(w, "Bad request: Book not found", http.StatusNotFound)
+			logger.Log.Info("Bad request: Book not found")
 			return
 		}
-	}(rows)
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
 
-	var finishedBooks []FinishedBook
-	for rows.Next() {
-		var book FinishedBook
-		err := rows.Scan(&book.ID, &book.Title, &book.Author, &book.CoverImage, &book.DateWhenAdded, &book.Rating, &book.Comment)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
-			logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+	if patch.Rating != nil && book.Status != StatusFinished {
+		http.Error(w, "Bad request: Only finished books can be rated", http.StatusBadRequest)
+		logger.Log.Info("Bad request: Only finished books can be rated")
+		return
+	}
+	if patch.PageCount != nil && book.CurrentPage != nil && *book.CurrentPage > *patch.PageCount {
+		http.Error(w, "Bad request: page_count is below the current page", http.StatusBadRequest)
+		logger.Log.Info("Bad request: page_count is below the current page")
+		return
+	}
+
+	book, err = h.books.Update(userID, bookID, &patch)
+	if err != nil {
+		http.Error(w, "Error updating book: "+err.Error(), http.StatusInternalServerError)
+		logger.Log.Info("Error updating book: " + err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(book)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while sending JSON: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Error while sending JSON: ") + err.Error())
+		return
+	}
+}
+
+func (h *handler) DeleteBook(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	bookID, err := strconv.Atoi(params.ByName("bookID"))
+	if err != nil {
+		http.Error(w, "Bad request: Invalid book ID", http.StatusBadRequest)
+		logger.Log.Info("Bad request: Invalid book ID")
+		return
+	}
+
+	err = h.books.Delete(params.ByName("uuid"), bookID)
+	if err != nil {
+		if err == ErrNotFound {
+			http.Error(w, "Bad request: Book not found", http.StatusNotFound)
+			logger.Log.Info("Bad request: Book not found")
 			return
 		}
-		finishedBooks = append(finishedBooks, book)
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if len(finishedBooks) == 0 {
-		w.Write([]byte("[]"))
+	// Owners deleting their own books is not security relevant, moderators
+	// deleting somebody else's is.
+	if caller, ok := CallerFromContext(r.Context()); ok && caller.ID != params.ByName("uuid") {
+		h.audit(r, AuditEvent{
+			Event:   AuditBookDeleted,
+			UserID:  params.ByName("uuid"),
+			Details: map[string]string{"book_id": strconv.Itoa(bookID)},
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *handler) GetFinishedBooks(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	query, ok := parseBookQuery(w, r, finishedSortColumns)
+	if !ok {
+		return
+	}
+
+	page, err := h.books.List(params.ByName("uuid"), StatusFinished, query)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
 		return
 	}
-	err = json.NewEncoder(w).Encode(finishedBooks)
+
+	finishedBooks := make([]FinishedBook, 0, len(page.Books))
+	for _, book := range page.Books {
+		finishedBooks = append(finishedBooks, FinishedBook{
+			ID:            book.ID,
+			Title:         book.Title,
+			Author:        book.Author,
+			CoverImage:    book.CoverImage,
+			DateWhenAdded: book.DateWhenAdded,
+			Rating:        book.Rating,
+			Comment:       book.Comment,
+		})
+	}
+
+	err = WriteList(w, r, finishedBooks, query.Page, page.Next)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error while sending JSON: ")+err.Error(), http.StatusServiceUnavailable)
 		logger.Log.Info(fmt.Sprintf("Error while sending JSON: ") + err.Error())
@@ -90,41 +312,68 @@ func (h *handler) GetFinishedBooks(w http.ResponseWriter, r *http.Request, param
 }
 
 func (h *handler) GetWishlistBooks(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	userID := params.ByName("uuid")
-	rows, err := h.db.Query("SELECT id, title, author, cover_image_url, date_added FROM books WHERE user_id = $1 AND is_read = $2", userID, false)
+	query, ok := parseBookQuery(w, r, wishlistSortColumns)
+	if !ok {
+		return
+	}
+
+	page, err := h.books.List(params.ByName("uuid"), StatusWantToRead, query)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
 		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
 		return
 	}
 
-	defer func(rows *sql.Rows) {
-		err := rows.Close()
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
-			logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
-			return
-		}
-	}(rows)
+	wishlistBooks := make([]WishlistBook, 0, len(page.Books))
+	for _, book := range page.Books {
+		wishlistBooks = append(wishlistBooks, WishlistBook{
+			ID:            book.ID,
+			Title:         book.Title,
+			Author:        book.Author,
+			CoverImage:    book.CoverImage,
+			DateWhenAdded: book.DateWhenAdded,
+		})
+	}
 
-	var wishlistBooks []WishlistBook
-	for rows.Next() {
-		var book WishlistBook
-		err := rows.Scan(&book.ID, &book.Title, &book.Author, &book.CoverImage, &book.DateWhenAdded)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
-			logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
-			return
-		}
-		wishlistBooks = append(wishlistBooks, book)
+	err = WriteList(w, r, wishlistBooks, query.Page, page.Next)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while sending JSON: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Error while sending JSON: ") + err.Error())
+		return
+	}
+}
+
+// GetReadingBooks lists the books being read, with their progress.
+func (h *handler) GetReadingBooks(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	h.listBooks(w, r, params, StatusReading)
+}
+
+// GetAbandonedBooks lists the books the user stopped reading.
+func (h *handler) GetAbandonedBooks(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	h.listBooks(w, r, params, StatusAbandoned)
+}
+
+func (h *handler) listBooks(w http.ResponseWriter, r *http.Request, params httprouter.Params, status BookStatus) {
+	query, ok := parseBookQuery(w, r, wishlistSortColumns)
+	if !ok {
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if len(wishlistBooks) == 0 {
-		w.Write([]byte("[]"))
+	page, err := h.books.List(params.ByName("uuid"), status, query)
+	if err == nil {
+		books := make([]*Book, 0, len(page.Books))
+		for i := range page.Books {
+			books = append(books, &page.Books[i])
+		}
+		err = h.addEstimates(params.ByName("uuid"), books...)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
 		return
 	}
-	err = json.NewEncoder(w).Encode(wishlistBooks)
+
+	err = WriteList(w, r, page.Books, query.Page, page.Next)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error while sending JSON: ")+err.Error(), http.StatusServiceUnavailable)
 		logger.Log.Info(fmt.Sprintf("Error while sending JSON: ") + err.Error())
@@ -132,6 +381,30 @@ func (h *handler) GetWishlistBooks(w http.ResponseWriter, r *http.Request, param
 	}
 }
 
+// parseBookQuery reads the sort, pagination and filter parameters of a list
+// endpoint and answers 400 itself if one of them is invalid.
+func parseBookQuery(w http.ResponseWriter, r *http.Request, sortColumns map[string]string) (BookQuery, bool) {
+	var query BookQuery
+	var err error
+	query.Sort, err = ParseSort(r.URL.Query().Get("sort"), defaultBookSort, sortColumns)
+	if err == nil {
+		query.Page, err = ParsePage(r.URL.Query(), query.Sort)
+	}
+	if err == nil {
+		query.Filter, err = ParseBookFilter(r.URL.Query())
+		query.Filter.Statuses = nil
+	}
+	if err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		logger.Log.Info("Bad request: " + err.Error())
+		return query, false
+	}
+	return query, true
+}
+
+// FromWishlistToFinished moves one of the path user's books that is not
+// finished yet, usually a wishlist book, to finished and responds with the
+// updated book. POST .../:bookID/finish does the same with a finish date.
 func (h *handler) FromWishlistToFinished(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	var additionalInfo FinishedBook // we can add comment + rating here
 	err := json.NewDecoder(r.Body).Decode(&additionalInfo)
@@ -149,26 +422,36 @@ func (h *handler) FromWishlistToFinished(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	counter, err := BookExists(bookID, h.db)
-
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
-		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+	// The rating is optional: 0 leaves the book unrated.
+	if additionalInfo.Rating < 0 || additionalInfo.Rating > 10 {
+		http.Error(w, "Bad request: Rating must be between 1 and 10, or 0 for no rating", http.StatusBadRequest)
+		logger.Log.Info("Bad request: Rating must be between 1 and 10, or 0 for no rating")
 		return
 	}
-	if counter == 0 {
-		http.Error(w, "Bad request: Book not found", http.StatusNotFound)
-		logger.Log.Info("Bad request: Book not found")
+
+	book, err := h.books.MarkFinished(params.ByName("uuid"), bookID, additionalInfo.Rating, additionalInfo.Comment)
+	if err != nil {
+		switch err {
+		case ErrNotFound:
+			http.Error(w, "Bad request: Book not found", http.StatusNotFound)
+			logger.Log.Info("Bad request: Book not found")
+		case ErrAlreadyFinished:
+			http.Error(w, "Bad request: Book is already finished", http.StatusConflict)
+			logger.Log.Info("Bad request: Book is already finished")
+		default:
+			http.Error(w, "Error updating book: "+err.Error(), http.StatusInternalServerError)
+			logger.Log.Info("Error updating book: " + err.Error())
+		}
 		return
 	}
 
-	_, err = h.db.Exec("UPDATE books SET is_read = $1, comment = $2, rating = $3 WHERE id = $4", true, additionalInfo.Comment, additionalInfo.Rating, additionalInfo.ID)
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(book)
 	if err != nil {
-		http.Error(w, "Error updating book: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Error while sending JSON: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Error while sending JSON: ") + err.Error())
 		return
 	}
-
-	w.WriteHeader(http.StatusOK)
 }
 
 func (h *handler) LoginUser(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
@@ -180,48 +463,86 @@ func (h *handler) LoginUser(w http.ResponseWriter, r *http.Request, params httpr
 		return
 	}
 
-	var userForToken User
-	err = h.db.QueryRow("SELECT user_id, username, password FROM users WHERE email = $1 AND EXISTS(SELECT 1 FROM users WHERE email = $1)",
-		loginRequest.Email).Scan(&userForToken.ID, &userForToken.Username, &userForToken.Password)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "User not found", http.StatusNotFound)
-			logger.Log.Info("User not found")
-			return
-		}
+	now := time.Now()
+	accountKey, ipKey := accountLimitKey(loginRequest.Email), h.ipLimitKey(r)
+	if wait := h.limiter.retryAfter(now, accountKey, ipKey); wait > 0 {
+		tooManyAttempts(w, wait)
+		logger.Log.Info("Login throttled for " + ipKey)
+		return
+	}
+
+	userForToken, err := h.users.ByEmail(loginRequest.Email)
+	if err != nil && err != ErrNotFound {
 		http.Error(w, "Database error: "+err.Error(), http.StatusServiceUnavailable)
 		logger.Log.Info("Database error: " + err.Error())
 		return
 	}
 
-	if loginRequest.Password != userForToken.Password {
-		http.Error(w, "Error when trying to login: incorrect password", http.StatusUnauthorized)
-		logger.Log.Info(fmt.Sprintf("Error when trying to login: incorrect password"))
+	// Unknown emails are checked against a dummy hash, so both failures take
+	// as long and answer the same.
+	storedPassword := dummyPasswordHash()
+	if userForToken != nil {
+		storedPassword = userForToken.Password
+	}
+	passwordOk, needsRehash := VerifyPassword(storedPassword, loginRequest.Password)
+	if userForToken == nil || !passwordOk {
+		h.limiter.failAccount(now, accountKey)
+		h.limiter.failIP(now, ipKey)
+		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		logger.Log.Info("Failed login from " + ipKey)
+		h.auditFailedLogin(r, userForToken, loginRequest.Email, "password")
+		return
+	}
+	h.limiter.reset(accountKey)
+
+	if userForToken.Disabled {
+		http.Error(w, "Account is disabled", http.StatusForbidden)
+		logger.Log.Info("Login of disabled user " + userForToken.ID)
+		h.auditFailedLogin(r, userForToken, loginRequest.Email, "disabled")
 		return
 	}
 
-	payload := jwt.MapClaims{
-		"sub": loginRequest.Email,
-		"exp": time.Now().Add(time.Hour * 14 * 24).Unix(),
+	if !userForToken.EmailVerified {
+		http.Error(w, "Email is not verified: follow the link from the confirmation email", http.StatusForbidden)
+		logger.Log.Info("Login of unverified user " + userForToken.ID)
+		h.auditFailedLogin(r, userForToken, loginRequest.Email, "unverified")
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, payload)
+	if needsRehash {
+		h.rehashPassword(userForToken.ID, loginRequest.Password)
+	}
 
-	if token == nil {
-		http.Error(w, "Error when creating token: "+err.Error(), http.StatusServiceUnavailable)
-		logger.Log.Info(fmt.Sprintf("Error when creating token: " + err.Error()))
+	if userForToken.TOTPEnabled {
+		h.challengeSecondFactor(w, userForToken, loginMethodPassword)
 		return
 	}
 
-	t, err := token.SignedString([]byte(h.cfg.Key.SecretKey))
+	h.startSession(w, r, userForToken, loginMethodPassword)
+}
+
+// startSession opens a new token family for user and responds with its first
+// tokens. method names how the user authenticated, for the audit log.
+func (h *handler) startSession(w http.ResponseWriter, r *http.Request, user *User, method string) {
+	familyID, err := h.tokens.CreateFamily(user.ID, userAgent(r), h.proxies.ClientIP(r))
 	if err != nil {
-		http.Error(w, "Error when signing the string: "+err.Error(), http.StatusServiceUnavailable)
-		logger.Log.Info(fmt.Sprintf("Error when signing the string: " + err.Error()))
+		http.Error(w, "Database error: "+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info("Database error: " + err.Error())
 		return
 	}
 
-	var responseToken LoginResponse
-	responseToken.AccessToken = t
+	responseToken, err := h.issueTokens(user, familyID)
+	if err != nil {
+		http.Error(w, "Error when issuing tokens: "+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info("Error when issuing tokens: " + err.Error())
+		return
+	}
+	h.audit(r, AuditEvent{
+		Event:   AuditLoginSucceeded,
+		UserID:  user.ID,
+		ActorID: user.ID,
+		Details: map[string]string{"method": method, "session": familyID},
+	})
 
 	jsonResponse, err := json.Marshal(responseToken)
 	if err != nil {
@@ -230,6 +551,7 @@ func (h *handler) LoginUser(w http.ResponseWriter, r *http.Request, params httpr
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
 
 	_, err = w.Write(jsonResponse)
 	if err != nil {
@@ -239,6 +561,20 @@ func (h *handler) LoginUser(w http.ResponseWriter, r *http.Request, params httpr
 	}
 }
 
+// rehashPassword upgrades a plaintext or outdated hash after a successful login.
+// Failures are only logged: the user has already proven the password.
+func (h *handler) rehashPassword(userID, password string) {
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		logger.Log.Error("Error when rehashing password: " + err.Error())
+		return
+	}
+	err = h.users.UpdatePassword(userID, passwordHash)
+	if err != nil {
+		logger.Log.Error("Error when storing rehashed password: " + err.Error())
+	}
+}
+
 func (h *handler) RegisterUser(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 
 	var requestUser User
@@ -255,7 +591,13 @@ func (h *handler) RegisterUser(w http.ResponseWriter, r *http.Request, params ht
 		return
 	}
 
-	used, err := IsUsernameEmailTaken(&requestUser, h.db)
+	if !ValidEmail(requestUser.Email) {
+		http.Error(w, "Bad request: Invalid email", http.StatusBadRequest)
+		logger.Log.Info("Bad request: Invalid email")
+		return
+	}
+
+	used, err := h.users.IsUsernameEmailTaken(requestUser.Username, requestUser.Email, "")
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Bad request: ")+err.Error(), http.StatusBadRequest)
 		logger.Log.Info(fmt.Sprintf("Bad request: ") + err.Error())
@@ -268,21 +610,23 @@ func (h *handler) RegisterUser(w http.ResponseWriter, r *http.Request, params ht
 		return
 	}
 
-	_, err = h.db.Exec("INSERT INTO users (username, password, email) VALUES ($1, $2, $3)",
-		requestUser.Username, requestUser.Password, requestUser.Email)
+	requestUser.Password, err = HashPassword(requestUser.Password)
+	if err != nil {
+		http.Error(w, "Error when hashing password: "+err.Error(), http.StatusInternalServerError)
+		logger.Log.Info("Error when hashing password: " + err.Error())
+		return
+	}
+
+	respondUser, err := h.users.Create(&requestUser)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
 		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
 		return
 	}
 
-	var respondUser User
-	err = h.db.QueryRow("SELECT user_id, username, email FROM users WHERE username = $1",
-		requestUser.Username).Scan(&respondUser.ID, &respondUser.Username, &respondUser.Email)
+	err = h.sendVerification(respondUser)
 	if err != nil {
-		http.Error(w, "User created, but while making JSON for respond: "+err.Error(), http.StatusServiceUnavailable)
-		logger.Log.Info(fmt.Sprintf("User created, but while making JSON for respond: ") + err.Error())
-		return
+		logger.Log.Error("User created, but verification was not sent: " + err.Error())
 	}
 
 	respondUserJSON, err := json.Marshal(respondUser)
@@ -304,31 +648,20 @@ func (h *handler) RegisterUser(w http.ResponseWriter, r *http.Request, params ht
 }
 
 func (h *handler) GetUserByUUID(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	var respondUser User
-	respondUser.ID = params.ByName("uuid")
-
-	intID, err := strconv.Atoi(respondUser.ID)
-	counter, err := IdExists(intID, h.db)
-
+	storedUser, err := h.users.ByID(params.ByName("uuid"))
 	if err != nil {
+		if err == ErrNotFound {
+			http.Error(w, "Bad request: User not found", http.StatusNotFound)
+			logger.Log.Info("Bad request: User not found")
+			return
+		}
 		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
 		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
 		return
 	}
-	if counter == 0 {
-		http.Error(w, "Bad request: User not found", http.StatusNotFound)
-		logger.Log.Info("Bad request: User not found")
-		return
-	}
-
-	err = h.db.QueryRow("SELECT username, email FROM users WHERE user_id = $1",
-		respondUser.ID).Scan(&respondUser.Username, &respondUser.Email)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusBadRequest)
-		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
-		return
-	}
 
+	respondUser := User{ID: storedUser.ID, Username: storedUser.Username, Email: storedUser.Email,
+		EmailVerified: storedUser.EmailVerified, Role: storedUser.Role, TOTPEnabled: storedUser.TOTPEnabled}
 	respondUserJSON, err := json.Marshal(respondUser)
 	if err != nil {
 		http.Error(w, "User was gotten, but while making JSON for respond: "+err.Error(), http.StatusInternalServerError)
@@ -347,14 +680,17 @@ func (h *handler) GetUserByUUID(w http.ResponseWriter, r *http.Request, params h
 	}
 }
 
+// FullyUpdateUser replaces username, email and password. Since the password
+// is always replaced, the current one has to be confirmed.
 func (h *handler) FullyUpdateUser(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	var requestUser User
-	err := json.NewDecoder(r.Body).Decode(&requestUser)
+	var request UpdateUserRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		logger.Log.Info(fmt.Sprintf("Bad request: ") + err.Error())
 		return
 	}
+	requestUser := request.User
 
 	uuid := params.ByName("uuid")
 
@@ -368,18 +704,17 @@ func (h *handler) FullyUpdateUser(w http.ResponseWriter, r *http.Request, params
 		requestUser.ID = uuid
 	}
 
-	intID, err := strconv.Atoi(requestUser.ID)
-	counter, err := IdExists(intID, h.db)
+	storedUser, err := h.users.ByID(requestUser.ID)
 	if err != nil {
+		if err == ErrNotFound {
+			http.Error(w, "Bad request: User not found", http.StatusNotFound)
+			logger.Log.Info("Bad request: User not found")
+			return
+		}
 		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
 		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
 		return
 	}
-	if counter == 0 {
-		http.Error(w, "Bad request: User not found", http.StatusNotFound)
-		logger.Log.Info("Bad request: User not found")
-		return
-	}
 
 	if !UserSuitableForRestrictions(len(requestUser.Username), len(requestUser.Password), len(requestUser.Email)) {
 		http.Error(w, "Too big length of username/password/email", http.StatusBadRequest)
@@ -387,7 +722,18 @@ func (h *handler) FullyUpdateUser(w http.ResponseWriter, r *http.Request, params
 		return
 	}
 
-	used, err := IsUsernameEmailTaken(&requestUser, h.db)
+	// PUT replaces the whole user, so the email is required.
+	if !ValidEmail(requestUser.Email) {
+		http.Error(w, "Bad request: Invalid email", http.StatusBadRequest)
+		logger.Log.Info("Bad request: Invalid email")
+		return
+	}
+
+	if !h.confirmSensitiveChange(w, r, storedUser, request.CurrentPassword) {
+		return
+	}
+
+	used, err := h.users.IsUsernameEmailTaken(requestUser.Username, requestUser.Email, requestUser.ID)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Bad request: ")+err.Error(), http.StatusBadRequest)
 		logger.Log.Info(fmt.Sprintf("Bad request: ") + err.Error())
@@ -400,25 +746,39 @@ func (h *handler) FullyUpdateUser(w http.ResponseWriter, r *http.Request, params
 		return
 	}
 
-	_, err = h.db.Exec("UPDATE users SET username = $1, password = $2, email = $3 WHERE user_id = $4;",
-		requestUser.Username, requestUser.Password, requestUser.Email, requestUser.ID)
+	requestUser.Password, err = HashPassword(requestUser.Password)
+	if err != nil {
+		http.Error(w, "Error when hashing password: "+err.Error(), http.StatusInternalServerError)
+		logger.Log.Info("Error when hashing password: " + err.Error())
+		return
+	}
+
+	err = h.users.Update(&requestUser)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
 		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
 		return
 	}
 
+	h.audit(r, AuditEvent{Event: AuditPasswordChanged, UserID: requestUser.ID})
+	h.endOtherSessions(r, requestUser.ID)
+	h.notifySensitiveChange(storedUser, &requestUser, true)
+	h.reverifyChangedEmail(r, storedUser, &requestUser)
+
 	w.WriteHeader(http.StatusOK)
 }
 
+// UpdateUser changes the given fields. Changing the email or password
+// requires confirming the current password.
 func (h *handler) UpdateUser(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	var requestUser User
-	err := json.NewDecoder(r.Body).Decode(&requestUser)
+	var request UpdateUserRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		logger.Log.Info(fmt.Sprintf("Bad request: ") + err.Error())
 		return
 	}
+	requestUser := request.User
 
 	uuid := params.ByName("uuid")
 
@@ -432,18 +792,17 @@ func (h *handler) UpdateUser(w http.ResponseWriter, r *http.Request, params http
 		requestUser.ID = uuid
 	}
 
-	intID, err := strconv.Atoi(requestUser.ID)
-	counter, err := IdExists(intID, h.db)
+	storedUser, err := h.users.ByID(requestUser.ID)
 	if err != nil {
+		if err == ErrNotFound {
+			http.Error(w, "Bad request: User not found", http.StatusNotFound)
+			logger.Log.Info("Bad request: User not found")
+			return
+		}
 		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
 		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
 		return
 	}
-	if counter == 0 {
-		http.Error(w, "Bad request: User not found", http.StatusNotFound)
-		logger.Log.Info("Bad request: User not found")
-		return
-	}
 
 	if !UserSuitableForRestrictions(len(requestUser.Username), len(requestUser.Password), len(requestUser.Email)) {
 		http.Error(w, "Too big length of username/password/email", http.StatusBadRequest)
@@ -451,7 +810,19 @@ func (h *handler) UpdateUser(w http.ResponseWriter, r *http.Request, params http
 		return
 	}
 
-	used, err := IsUsernameEmailTaken(&requestUser, h.db)
+	if requestUser.Email != "" && !ValidEmail(requestUser.Email) {
+		http.Error(w, "Bad request: Invalid email", http.StatusBadRequest)
+		logger.Log.Info("Bad request: Invalid email")
+		return
+	}
+
+	passwordChanged := requestUser.Password != ""
+	emailChanged := requestUser.Email != "" && requestUser.Email != storedUser.Email
+	if (passwordChanged || emailChanged) && !h.confirmSensitiveChange(w, r, storedUser, request.CurrentPassword) {
+		return
+	}
+
+	used, err := h.users.IsUsernameEmailTaken(requestUser.Username, requestUser.Email, requestUser.ID)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Bad request: ")+err.Error(), http.StatusBadRequest)
 		logger.Log.Info(fmt.Sprintf("Bad request: ") + err.Error())
@@ -464,89 +835,107 @@ func (h *handler) UpdateUser(w http.ResponseWriter, r *http.Request, params http
 		return
 	}
 
-	var realUsername, realEmail string
-	err = h.db.QueryRow("SELECT username, email FROM users WHERE user_id = $1",
-		requestUser.ID).Scan(&realUsername, &realEmail)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusBadRequest)
-		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
-		return
-	}
-
 	if requestUser.Username == "" {
-		requestUser.Username = realUsername
+		requestUser.Username = storedUser.Username
 	}
 	if requestUser.Email == "" {
-		requestUser.Email = realEmail
+		requestUser.Email = storedUser.Email
+	}
+	if !passwordChanged {
+		requestUser.Password = storedUser.Password
+	} else {
+		requestUser.Password, err = HashPassword(requestUser.Password)
+		if err != nil {
+			http.Error(w, "Error when hashing password: "+err.Error(), http.StatusInternalServerError)
+			logger.Log.Info("Error when hashing password: " + err.Error())
+			return
+		}
 	}
-	_, err = h.db.Exec("UPDATE users SET username = $1, email = $2 WHERE user_id = $3;",
-		requestUser.Username, requestUser.Email, requestUser.ID)
+
+	err = h.users.Update(&requestUser)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
 		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
 		return
 	}
 
-	if requestUser.Password != "" {
-		_, err = h.db.Exec("UPDATE users SET password = $1 WHERE user_id = $2;",
-			requestUser.Password, requestUser.ID)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
-			logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
-			return
-		}
+	if passwordChanged {
+		h.audit(r, AuditEvent{Event: AuditPasswordChanged, UserID: requestUser.ID})
+		h.endOtherSessions(r, requestUser.ID)
 	}
+	if passwordChanged || emailChanged {
+		h.notifySensitiveChange(storedUser, &requestUser, passwordChanged)
+	}
+	h.reverifyChangedEmail(r, storedUser, &requestUser)
 
 	w.WriteHeader(http.StatusOK)
 }
 
-func (h *handler) DeleteUser(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	uuid := params.ByName("uuid")
-
-	intID, err := strconv.Atoi(uuid)
-	counter, err := IdExists(intID, h.db)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
-		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+// reverifyChangedEmail records and sends a verification link for an update
+// that changed the email.
+func (h *handler) reverifyChangedEmail(r *http.Request, before, after *User) {
+	if before.Email == after.Email {
 		return
 	}
-	if counter == 0 {
-		http.Error(w, "Bad request: User not found", http.StatusNotFound)
-		logger.Log.Info("Bad request: User not found")
-		return
+	h.audit(r, AuditEvent{
+		Event:   AuditEmailChanged,
+		UserID:  after.ID,
+		Details: map[string]string{"old": before.Email, "new": after.Email},
+	})
+	err := h.sendVerification(after)
+	if err != nil {
+		logger.Log.Error("Email changed, but verification was not sent: " + err.Error())
 	}
+}
 
-	_, err = h.db.Exec("DELETE FROM users WHERE user_id = $1", uuid)
+func (h *handler) DeleteUser(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	err := h.users.Delete(params.ByName("uuid"))
 	if err != nil {
+		if err == ErrNotFound {
+			http.Error(w, "Bad request: User not found", http.StatusNotFound)
+			logger.Log.Info("Bad request: User not found")
+			return
+		}
 		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
 		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
 		return
 	}
+	// The audit log has no foreign keys, so the record outlives the user.
+	h.audit(r, AuditEvent{Event: AuditUserDeleted, UserID: params.ByName("uuid")})
 
 	w.WriteHeader(http.StatusOK)
 }
 
 func (h *handler) AddFinishedBook(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	AddBook(w, r, params, true, h.db)
+	h.addBook(w, r, params, StatusFinished)
 }
 
 func (h *handler) AddWishlistBook(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	AddBook(w, r, params, false, h.db)
+	h.addBook(w, r, params, StatusWantToRead)
+}
+
+// AddReadingBook adds a book the user has started reading today.
+func (h *handler) AddReadingBook(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	h.addBook(w, r, params, StatusReading)
 }
 
-func AddBook(w http.ResponseWriter, r *http.Request, params httprouter.Params, isFinished bool, db *sql.DB) {
+func (h *handler) addBook(w http.ResponseWriter, r *http.Request, params httprouter.Params, status BookStatus) {
 
-	var book interface{}
+	var book Book
 	var err error
 
-	if isFinished {
+	if status == StatusFinished {
 		finishedBook := FinishedBook{}
 		err = json.NewDecoder(r.Body).Decode(&finishedBook)
-		book = finishedBook
+		book = Book{Title: finishedBook.Title, Author: finishedBook.Author, CoverImage: finishedBook.CoverImage,
+			Rating: finishedBook.Rating, Comment: finishedBook.Comment, Status: status}
 	} else {
 		wishlistBook := WishlistBook{}
 		err = json.NewDecoder(r.Body).Decode(&wishlistBook)
-		book = wishlistBook
+		book = Book{Title: wishlistBook.Title, Author: wishlistBook.Author, CoverImage: wishlistBook.CoverImage, Status: status}
+	}
+	if status == StatusReading {
+		book.StartedAt = time.Now().Format(filterDateLayout)
 	}
 
 	if err != nil {
@@ -555,25 +944,13 @@ func AddBook(w http.ResponseWriter, r *http.Request, params httprouter.Params, i
 		return
 	}
 
-	userID := params.ByName("uuid")
-
-	now := time.Now()
-	year, month, day := now.Date()
-	date := fmt.Sprintf("%d-%d-%d", year, month, day)
-
-	switch s := book.(type) {
-	case WishlistBook:
-		_, err = db.Exec(`
-		INSERT INTO books (title, author, date_added, user_id, is_read, cover_image_url)
-		VALUES ($1, $2, $3, $4, $5, COALESCE($6, ''))
-		`, s.Title, s.Author, date, userID, 0, s.CoverImage)
-	case FinishedBook:
-		_, err = db.Exec(`
-		INSERT INTO books (title, author, date_added, user_id, is_read, rating, comment, cover_image_url)
-		VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7, ''), COALESCE($8, ''))
-		`, s.Title, s.Author, date, userID, 1, s.Rating, s.Comment, s.CoverImage)
+	if book.Rating < 0 || book.Rating > 10 {
+		http.Error(w, "Bad request: Rating must be between 1 and 10, or 0 for no rating", http.StatusBadRequest)
+		logger.Log.Info("Bad request: Rating must be between 1 and 10, or 0 for no rating")
+		return
 	}
 
+	_, err = h.books.Add(params.ByName("uuid"), &book)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
 		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
@@ -0,0 +1,346 @@
+package user
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"myLibrary/internal/config"
+	"myLibrary/package/client/mailer"
+	"myLibrary/package/logger"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// testMailer keeps the messages instead of sending them.
+type testMailer struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (m *testMailer) Send(message mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+type testServer struct {
+	*httptest.Server
+	repos Repositories
+	mail  *testMailer
+}
+
+// newTestServer serves the user handler from in-memory repositories.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Key.SecretKey = "test-secret"
+	cfg.Key.AccessTTL = time.Minute
+	cfg.Key.RefreshTTL = time.Hour
+	cfg.Key.ResetTTL = time.Hour
+	cfg.Login.MaxFailures = 5
+	cfg.Login.Lockout = time.Minute
+	cfg.Login.BackoffBase = time.Millisecond
+	cfg.Login.IPMaxFailures = 100
+	cfg.Login.IPLockout = time.Minute
+
+	router := httprouter.New()
+	server := &testServer{
+		Server: httptest.NewServer(router),
+		repos:  NewMemoryRepositories(),
+		mail:   &testMailer{},
+	}
+	t.Cleanup(server.Close)
+	cfg.PublicURL = server.URL
+	NewHandler(server.repos, server.mail, cfg).Register(router)
+	return server
+}
+
+// do sends body to path with token as the bearer token, if any, and returns
+// the status and the response body.
+func (s *testServer) do(t *testing.T, method, path, token, body string) (int, string) {
+	t.Helper()
+	request, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.StatusCode, string(content)
+}
+
+// signUp registers a user with a verified email and the password "password"
+// and returns its ID.
+func (s *testServer) signUp(t *testing.T, username, email string) string {
+	t.Helper()
+	status, body := s.do(t, http.MethodPost, RegisterUrl, "",
+		`{"username":"`+username+`","password":"password","email":"`+email+`"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("register %s: %d %s", email, status, body)
+	}
+	var created User
+	if err := json.Unmarshal([]byte(body), &created); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.repos.Users.MarkEmailVerified(created.ID, email); err != nil {
+		t.Fatal(err)
+	}
+	return created.ID
+}
+
+func (s *testServer) login(t *testing.T, email string) *LoginResponse {
+	t.Helper()
+	status, body := s.do(t, http.MethodPost, LoginUrl, "", `{"email":"`+email+`","password":"password"}`)
+	if status != http.StatusOK {
+		t.Fatalf("login %s: %d %s", email, status, body)
+	}
+	return decodeTokens(t, body)
+}
+
+func (s *testServer) refresh(t *testing.T, refreshToken string) (int, string) {
+	t.Helper()
+	return s.do(t, http.MethodPost, RefreshTokenUrl, "", `{"refresh_token":"`+refreshToken+`"}`)
+}
+
+func decodeTokens(t *testing.T, body string) *LoginResponse {
+	t.Helper()
+	var tokens LoginResponse
+	if err := json.Unmarshal([]byte(body), &tokens); err != nil {
+		t.Fatal(err)
+	}
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Fatalf("no tokens in %s", body)
+	}
+	return &tokens
+}
+
+func TestOwnerOnly(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signUp(t, "alice", "alice@example.com")
+	bob := s.signUp(t, "bob", "bob@example.com")
+	tokens := s.login(t, "alice@example.com")
+
+	if status, body := s.do(t, http.MethodGet, "/user/"+alice, tokens.AccessToken, ""); status != http.StatusOK {
+		t.Errorf("own user: %d %s", status, body)
+	}
+	for _, path := range []string{"/user/" + bob, "/user/" + bob + BooksUrl + FinishedBooksUrl, "/user/abc"} {
+		if status, _ := s.do(t, http.MethodGet, path, tokens.AccessToken, ""); status != http.StatusForbidden {
+			t.Errorf("GET %s: got %d, want 403", path, status)
+		}
+	}
+	status, _ := s.do(t, http.MethodPost, "/user/"+bob+BooksUrl+WishlistBooksUrl, tokens.AccessToken,
+		`{"title":"Dune","author":"Herbert"}`)
+	if status != http.StatusForbidden {
+		t.Errorf("adding a book for another user: got %d, want 403", status)
+	}
+	if status, _ := s.do(t, http.MethodGet, "/user/"+alice, "", ""); status != http.StatusUnauthorized {
+		t.Errorf("no token: got %d, want 401", status)
+	}
+}
+
+func TestRefreshRotation(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signUp(t, "alice", "alice@example.com")
+	first := s.login(t, "alice@example.com")
+
+	status, body := s.refresh(t, first.RefreshToken)
+	if status != http.StatusOK {
+		t.Fatalf("refresh: %d %s", status, body)
+	}
+	second := decodeTokens(t, body)
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("refresh token was not rotated")
+	}
+	if status, body := s.do(t, http.MethodGet, "/user/"+alice, second.AccessToken, ""); status != http.StatusOK {
+		t.Fatalf("new access token: %d %s", status, body)
+	}
+
+	status, body = s.refresh(t, second.RefreshToken)
+	if status != http.StatusOK {
+		t.Fatalf("refresh with the rotated token: %d %s", status, body)
+	}
+	third := decodeTokens(t, body)
+
+	// Replaying a spent token means it leaked: the whole session ends.
+	if status, _ := s.refresh(t, first.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("reused refresh token: got %d, want 401", status)
+	}
+	if status, _ := s.refresh(t, third.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("latest refresh token after reuse: got %d, want 401", status)
+	}
+	if status, _ := s.do(t, http.MethodGet, "/user/"+alice, third.AccessToken, ""); status != http.StatusUnauthorized {
+		t.Errorf("access token after reuse: got %d, want 401", status)
+	}
+
+	// Other sessions are not affected.
+	other := s.login(t, "alice@example.com")
+	if status, body := s.refresh(t, other.RefreshToken); status != http.StatusOK {
+		t.Errorf("refresh of another session: %d %s", status, body)
+	}
+}
+
+// rfc6238Secret is the SHA1 key of the RFC 6238 test vectors,
+// "12345678901234567890", in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The RFC gives 8 digit codes; these are their last 6 digits.
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTOTPCode(t *testing.T) {
+	for _, vector := range rfc6238Vectors {
+		code, err := totpCode(rfc6238Secret, vector.unix/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != vector.code {
+			t.Errorf("T=%d: got %s, want %s", vector.unix, code, vector.code)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	for _, vector := range rfc6238Vectors {
+		now := time.Unix(vector.unix, 0)
+		for _, drift := range []int64{-totpPeriod, 0, totpPeriod} {
+			step, ok := verifyTOTP(rfc6238Secret, vector.code, now.Add(time.Duration(drift)*time.Second))
+			if !ok || step != vector.unix/totpPeriod {
+				t.Errorf("T=%d, drift %ds: got step %d, %v", vector.unix, drift, step, ok)
+			}
+		}
+		if _, ok := verifyTOTP(rfc6238Secret, vector.code, now.Add(3*totpPeriod*time.Second)); ok {
+			t.Errorf("T=%d: code accepted three periods later", vector.unix)
+		}
+	}
+	if _, ok := verifyTOTP(rfc6238Secret, "28708", time.Unix(59, 0)); ok {
+		t.Error("short code accepted")
+	}
+}
+
+func TestSessionSurvivesEmailChange(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signUp(t, "alice", "alice@example.com")
+	tokens := s.login(t, "alice@example.com")
+
+	status, body := s.do(t, http.MethodPatch, "/user/"+alice, tokens.AccessToken,
+		`{"email":"alice@example.org","current_password":"password"}`)
+	if status != http.StatusOK {
+		t.Fatalf("email change: %d %s", status, body)
+	}
+	status, body = s.do(t, http.MethodGet, "/user/"+alice, tokens.AccessToken, "")
+	if status != http.StatusOK || !strings.Contains(body, "alice@example.org") {
+		t.Fatalf("after the email change: %d %s", status, body)
+	}
+
+	// Whoever registers the old address gets an account of their own.
+	mallory := s.signUp(t, "mallory", "alice@example.com")
+	stolen := s.login(t, "alice@example.com")
+	if status, _ := s.do(t, http.MethodGet, "/user/"+alice, stolen.AccessToken, ""); status != http.StatusForbidden {
+		t.Errorf("new owner of the old email reading the old account: got %d, want 403", status)
+	}
+	if status, _ := s.do(t, http.MethodGet, "/user/"+mallory, tokens.AccessToken, ""); status != http.StatusForbidden {
+		t.Errorf("old session reading the new owner of its email: got %d, want 403", status)
+	}
+	if status, body := s.do(t, http.MethodGet, "/user/"+alice, tokens.AccessToken, ""); status != http.StatusOK {
+		t.Errorf("old session after the email was registered again: %d %s", status, body)
+	}
+}
+
+func TestPasswordChangeRevokesAPIKeys(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signUp(t, "alice", "alice@example.com")
+	tokens := s.login(t, "alice@example.com")
+
+	status, body := s.do(t, http.MethodPost, "/user/"+alice+KeysUrl, tokens.AccessToken, `{"name":"script"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("creating a key: %d %s", status, body)
+	}
+	var created CreatedAPIKey
+	if err := json.Unmarshal([]byte(body), &created); err != nil {
+		t.Fatal(err)
+	}
+	if status, body := s.do(t, http.MethodGet, "/user/"+alice, created.Key, ""); status != http.StatusOK {
+		t.Fatalf("the new key: %d %s", status, body)
+	}
+
+	status, body = s.do(t, http.MethodPatch, "/user/"+alice, tokens.AccessToken,
+		`{"password":"new password","current_password":"password"}`)
+	if status != http.StatusOK {
+		t.Fatalf("password change: %d %s", status, body)
+	}
+	if status, _ := s.do(t, http.MethodGet, "/user/"+alice, created.Key, ""); status != http.StatusUnauthorized {
+		t.Errorf("key after the password change: got %d, want 401", status)
+	}
+	if status, body := s.do(t, http.MethodGet, "/user/"+alice, tokens.AccessToken, ""); status != http.StatusOK {
+		t.Errorf("the session that changed the password: %d %s", status, body)
+	}
+}
+
+func TestAddFinishedBookRating(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signUp(t, "alice", "alice@example.com")
+	tokens := s.login(t, "alice@example.com")
+
+	path := "/user/" + alice + BooksUrl + FinishedBooksUrl
+	for rating, want := range map[string]int{
+		"-1": http.StatusBadRequest,
+		"11": http.StatusBadRequest,
+		"0":  http.StatusCreated,
+		"10": http.StatusCreated,
+	} {
+		status, body := s.do(t, http.MethodPost, path, tokens.AccessToken, `{"title":"Dune","author":"Herbert","rating":`+rating+`}`)
+		if status != want {
+			t.Errorf("rating %s: got %d %s, want %d", rating, status, body, want)
+		}
+	}
+}
+
+func TestRegisterRejectsInvalidEmail(t *testing.T) {
+	s := newTestServer(t)
+	for _, email := range []string{"foo", "Reader <reader@example.com>", ""} {
+		status, body := s.do(t, http.MethodPost, RegisterUrl, "",
+			`{"username":"reader","password":"password","email":"`+email+`"}`)
+		if status != http.StatusBadRequest {
+			t.Errorf("register %q: got %d %s, want 400", email, status, body)
+		}
+	}
+	if len(s.mail.messages) != 0 {
+		t.Errorf("%d verification mails sent to invalid addresses", len(s.mail.messages))
+	}
+
+	alice := s.signUp(t, "alice", "alice@example.com")
+	tokens := s.login(t, "alice@example.com")
+	status, _ := s.do(t, http.MethodPatch, "/user/"+alice, tokens.AccessToken, `{"email":"foo","current_password":"password"}`)
+	if status != http.StatusBadRequest {
+		t.Errorf("changing the email to foo: got %d, want 400", status)
+	}
+}
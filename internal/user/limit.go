@@ -0,0 +1,76 @@
+package user
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"myLibrary/internal/config"
+	"myLibrary/internal/ratelimit"
+	"myLibrary/package/logger"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// rateLimits are the route groups of config.RateLimits; handler.Register
+// puts every route into one of them.
+type rateLimits struct {
+	auth  *ratelimit.Limiter
+	read  *ratelimit.Limiter
+	write *ratelimit.Limiter
+	admin *ratelimit.Limiter
+}
+
+func newRateLimits(cfg config.RateLimits) rateLimits {
+	build := func(limit config.RateLimit) *ratelimit.Limiter {
+		return ratelimit.New(limit.Requests, limit.Per, limit.Burst)
+	}
+	return rateLimits{
+		auth:  build(cfg.Auth),
+		read:  build(cfg.Read),
+		write: build(cfg.Write),
+		admin: build(cfg.Admin),
+	}
+}
+
+// limit is the outermost middleware of every route. It answers 429 once the
+// client has used up its bucket in limiter, and reports the bucket state in
+// RateLimit-* headers either way.
+func (h *handler) limit(limiter *ratelimit.Limiter, next httprouter.Handle) httprouter.Handle {
+	if limiter == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		key := h.rateLimitKey(r)
+		decision := limiter.Allow(key, time.Now())
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+
+		if !decision.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(decision.RetryAfter)))
+			http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+			logger.Log.Info("Rate limited " + key + " on " + r.Method + " " + r.URL.Path)
+			return
+		}
+
+		next(w, r, params)
+	}
+}
+
+// rateLimitKey identifies the client: the owner of a validly signed access
+// token, or else the client IP. Only the signature is checked here;
+// authenticate still decides whether the token is accepted. API keys can only
+// be told from made-up ones in the database, which the limiter must not query
+// before limiting, so requests with an API key are limited per IP.
+func (h *handler) rateLimitKey(r *http.Request) string {
+	if tokenString, ok := bearerToken(r); ok && !isAPIKey(tokenString) {
+		if userID, _, err := h.parseAccessToken(tokenString); err == nil {
+			return "user:" + userID
+		}
+	}
+	return "ip:" + h.proxies.ClientIP(r)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,89 @@
+package user
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"myLibrary/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// countingAPIKeys counts the key lookups.
+type countingAPIKeys struct {
+	APIKeyRepository
+	lookups int
+}
+
+func (r *countingAPIKeys) ByHash(keyHash string) (*APIKey, error) {
+	r.lookups++
+	return r.APIKeyRepository.ByHash(keyHash)
+}
+
+func newLimitedHandler(t *testing.T, repos Repositories) *handler {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Key.SecretKey = "test-secret"
+	cfg.Key.AccessTTL = time.Minute
+	cfg.Key.RefreshTTL = time.Hour
+	cfg.RateLimit.Read = config.RateLimit{Requests: 2, Per: time.Minute}
+	return NewHandler(repos, &testMailer{}, cfg).(*handler)
+}
+
+func TestRateLimitAPIKeysWithoutLookups(t *testing.T) {
+	repos := NewMemoryRepositories()
+	apiKeys := &countingAPIKeys{APIKeyRepository: repos.APIKeys}
+	repos.APIKeys = apiKeys
+	h := newLimitedHandler(t, repos)
+
+	passed := 0
+	limited := h.limit(h.limits.read, func(http.ResponseWriter, *http.Request, httprouter.Params) { passed++ })
+	statuses := make([]int, 0, 3)
+	for i := 0; i < 3; i++ {
+		request := httptest.NewRequest(http.MethodGet, "/user/1", nil)
+		request.Header.Set("Authorization", "Bearer "+apiKeyPrefix+"made-up-"+strconv.Itoa(i))
+		recorder := httptest.NewRecorder()
+		limited(recorder, request, nil)
+		statuses = append(statuses, recorder.Code)
+	}
+
+	if passed != 2 || statuses[2] != http.StatusTooManyRequests {
+		t.Errorf("made-up keys from one IP: %d passed, statuses %v", passed, statuses)
+	}
+	if apiKeys.lookups != 0 {
+		t.Errorf("the limiter looked up %d keys", apiKeys.lookups)
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	repos := NewMemoryRepositories()
+	h := newLimitedHandler(t, repos)
+	reader, err := repos.Users.Create(&User{Username: "reader", Email: "reader@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	familyID, err := repos.Tokens.CreateFamily(reader.ID, "test", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := h.issueTokens(reader, familyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for bearer, want := range map[string]string{
+		tokens.AccessToken:       "user:" + reader.ID,
+		"not-a-token":            "ip:192.0.2.1",
+		apiKeyPrefix + "made-up": "ip:192.0.2.1",
+		"":                       "ip:192.0.2.1",
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/user/"+reader.ID, nil)
+		if bearer != "" {
+			request.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		if key := h.rateLimitKey(request); key != want {
+			t.Errorf("bearer %.20q: key %q, want %q", bearer, key, want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package user
+
+import (
+	"myLibrary/internal/config"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loginLimiter slows down password and 2FA code guessing. Every failed
+// attempt is counted per account and per client IP:
+//   - an account has to wait backoff_base, then twice as long after each
+//     further failure, and is locked for lockout after max_failures;
+//   - an IP is locked for ip_lockout after ip_max_failures, whatever accounts
+//     it tried.
+//
+// Accounts are keyed by the email as typed, so unknown emails are limited
+// exactly like real ones and the limiter does not reveal who is registered.
+// Counters live in process memory and are forgotten after a quiet lockout
+// period.
+type loginLimiter struct {
+	cfg config.LoginLimits
+
+	mu        sync.Mutex
+	attempts  map[string]*loginAttempts
+	lastSweep time.Time
+}
+
+type loginAttempts struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+func newLoginLimiter(cfg config.LoginLimits) *loginLimiter {
+	return &loginLimiter{cfg: cfg, attempts: make(map[string]*loginAttempts)}
+}
+
+func accountLimitKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func (h *handler) ipLimitKey(r *http.Request) string {
+	return "ip:" + h.proxies.ClientIP(r)
+}
+
+// retryAfter returns how long the caller has to wait before any of keys may try again.
+func (l *loginLimiter) retryAfter(now time.Time, keys ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var wait time.Duration
+	for _, key := range keys {
+		if attempts, ok := l.attempts[key]; ok && attempts.blockedUntil.After(now) {
+			if remaining := attempts.blockedUntil.Sub(now); remaining > wait {
+				wait = remaining
+			}
+		}
+	}
+	return wait
+}
+
+func (l *loginLimiter) failAccount(now time.Time, key string) {
+	l.fail(now, key, l.cfg.MaxFailures, l.cfg.Lockout, true)
+}
+
+func (l *loginLimiter) failIP(now time.Time, key string) {
+	l.fail(now, key, l.cfg.IPMaxFailures, l.cfg.IPLockout, false)
+}
+
+func (l *loginLimiter) fail(now time.Time, key string, maxFailures int, lockout time.Duration, backoff bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	attempts, ok := l.attempts[key]
+	if !ok || now.Sub(attempts.lastFailure) > lockout {
+		attempts = &loginAttempts{}
+		l.attempts[key] = attempts
+	}
+	attempts.failures++
+	attempts.lastFailure = now
+
+	switch {
+	case maxFailures > 0 && attempts.failures >= maxFailures:
+		attempts.blockedUntil = now.Add(lockout)
+	case backoff && l.cfg.BackoffBase > 0:
+		delay := l.cfg.BackoffBase << (attempts.failures - 1)
+		if delay <= 0 || delay > lockout {
+			delay = lockout
+		}
+		attempts.blockedUntil = now.Add(delay)
+	}
+}
+
+// reset forgets the failures of key after a successful login.
+func (l *loginLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.attempts, key)
+}
+
+// sweep drops counters that can no longer block anyone. Callers hold mu.
+func (l *loginLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	quiet := l.cfg.Lockout
+	if l.cfg.IPLockout > quiet {
+		quiet = l.cfg.IPLockout
+	}
+	for key, attempts := range l.attempts {
+		if now.Sub(attempts.lastFailure) > quiet && !attempts.blockedUntil.After(now) {
+			delete(l.attempts, key)
+		}
+	}
+}
+
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+	http.Error(w, "Too many login attempts, try again later", http.StatusTooManyRequests)
+}
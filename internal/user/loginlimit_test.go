@@ -0,0 +1,76 @@
+package user
+
+import (
+	"myLibrary/internal/config"
+	"testing"
+	"time"
+)
+
+var testLoginLimits = config.LoginLimits{
+	MaxFailures:   3,
+	Lockout:       time.Minute,
+	BackoffBase:   time.Second,
+	IPMaxFailures: 2,
+	IPLockout:     time.Hour,
+}
+
+func TestLoginLimiterBackoffAndLockout(t *testing.T) {
+	limiter := newLoginLimiter(testLoginLimits)
+	now := time.Unix(1700000000, 0)
+	const key = "account:reader@example.com"
+
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, time.Minute} {
+		limiter.failAccount(now, key)
+		if wait := limiter.retryAfter(now, key); wait != want {
+			t.Fatalf("after failure %d: wait %v, want %v", i+1, wait, want)
+		}
+		now = now.Add(limiter.retryAfter(now, key))
+	}
+	if wait := limiter.retryAfter(now, key); wait != 0 {
+		t.Errorf("after the lockout: wait %v", wait)
+	}
+	if wait := limiter.retryAfter(now, "account:other@example.com"); wait != 0 {
+		t.Errorf("another account: wait %v", wait)
+	}
+
+	// A failure long after the last one starts counting anew.
+	now = now.Add(2 * time.Minute)
+	limiter.failAccount(now, key)
+	if wait := limiter.retryAfter(now, key); wait != time.Second {
+		t.Errorf("after a quiet period: wait %v, want 1s", wait)
+	}
+
+	limiter.reset(key)
+	if wait := limiter.retryAfter(now, key); wait != 0 {
+		t.Errorf("after reset: wait %v", wait)
+	}
+}
+
+func TestLoginLimiterIP(t *testing.T) {
+	limiter := newLoginLimiter(testLoginLimits)
+	now := time.Unix(1700000000, 0)
+	const key = "ip:192.0.2.1"
+
+	// IPs get no backoff, only the lockout.
+	limiter.failIP(now, key)
+	if wait := limiter.retryAfter(now, key); wait != 0 {
+		t.Errorf("after one failure: wait %v", wait)
+	}
+	limiter.failIP(now, key)
+	if wait := limiter.retryAfter(now, key, "account:reader@example.com"); wait != time.Hour {
+		t.Errorf("after two failures: wait %v, want 1h", wait)
+	}
+}
+
+func TestLoginLimiterZeroTurnsOff(t *testing.T) {
+	limiter := newLoginLimiter(config.LoginLimits{Lockout: time.Minute, IPLockout: time.Minute})
+	now := time.Unix(1700000000, 0)
+
+	for i := 0; i < 10; i++ {
+		limiter.failAccount(now, "account:reader@example.com")
+		limiter.failIP(now, "ip:192.0.2.1")
+	}
+	if wait := limiter.retryAfter(now, "account:reader@example.com", "ip:192.0.2.1"); wait != 0 {
+		t.Errorf("wait %v with lockouts and backoff off", wait)
+	}
+}
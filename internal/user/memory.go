@@ -0,0 +1,1204 @@
+package user
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// NewMemoryRepositories returns repositories that keep everything in process
+// memory. They follow the PostgreSQL semantics closely enough to run the HTTP
+// layer without a database.
+func NewMemoryRepositories() Repositories {
+	store := &memoryStore{
+		users:         make(map[string]*User),
+		books:         make(map[int]*memoryBook),
+		families:      make(map[string]*memoryFamily),
+		refreshTokens: make(map[string]*memoryRefreshToken),
+		resets:        make(map[string]*memoryReset),
+		totpSteps:     make(map[string]int64),
+		recoveryCodes: make(map[string]map[string]bool),
+		apiKeys:       make(map[string]*memoryAPIKey),
+		identities:    make(map[memoryIdentity]string),
+	}
+	return Repositories{
+		Users:           &memoryUsers{store},
+		Books:           &memoryBooks{store},
+		Tokens:          &memoryTokens{store},
+		APIKeys:         &memoryAPIKeys{store},
+		Audit:           &memoryAudit{store},
+		ReadingSessions: &memoryReadingSessions{store},
+	}
+}
+
+type memoryStore struct {
+	mu            sync.Mutex
+	users         map[string]*User
+	books         map[int]*memoryBook
+	families      map[string]*memoryFamily
+	refreshTokens map[string]*memoryRefreshToken
+	resets        map[string]*memoryReset
+	totpSteps     map[string]int64
+	// recoveryCodes maps a user ID to their code hashes and whether each is used.
+	recoveryCodes map[string]map[string]bool
+	apiKeys       map[string]*memoryAPIKey
+	// identities maps OIDC provider accounts to user IDs.
+	identities   map[memoryIdentity]string
+	auditLog     []AuditEvent
+	lastUserID   int
+	lastBookID   int
+	lastAPIKeyID int
+	// readingSessions is ordered by ID. Sessions of deleted books are skipped
+	// rather than removed.
+	readingSessions []memoryReadingSession
+}
+
+type memoryBook struct {
+	Book
+	userID string
+	added  time.Time
+}
+
+// view is the book as it is read back: with a current page and a page count
+// the percentage is derived, as in the bookColumns of PostgreSQL.
+func (b *memoryBook) view() Book {
+	book := b.Book
+	if book.CurrentPage != nil && book.PageCount != nil {
+		percent := *book.CurrentPage * 100 / *book.PageCount
+		if percent > 100 {
+			percent = 100
+		}
+		book.ProgressPercent = &percent
+	}
+	return book
+}
+
+type memoryUsers struct {
+	*memoryStore
+}
+
+func (r *memoryUsers) Create(user *User) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastUserID++
+	stored := *user
+	stored.ID = strconv.Itoa(r.lastUserID)
+	stored.EmailVerified = false
+	stored.Role = RoleUser
+	stored.Disabled = false
+	stored.TOTPEnabled = false
+	stored.TOTPSecret = ""
+	r.users[stored.ID] = &stored
+
+	created := User{ID: stored.ID, Username: stored.Username, Email: stored.Email, Role: stored.Role}
+	return &created, nil
+}
+
+func (r *memoryUsers) ByID(id string) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	found := *user
+	return &found, nil
+}
+
+func (r *memoryUsers) ByEmail(email string) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if user.Email == email {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryUsers) IsUsernameEmailTaken(username, email, exceptID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, user := range r.users {
+		if id != exceptID && (user.Username == username || user.Email == email) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryUsers) Update(user *User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous, ok := r.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored := *user
+	stored.EmailVerified = previous.EmailVerified && previous.Email == user.Email
+	stored.Role = previous.Role
+	stored.Disabled = previous.Disabled
+	stored.TOTPEnabled = previous.TOTPEnabled
+	stored.TOTPSecret = previous.TOTPSecret
+	r.users[user.ID] = &stored
+	return nil
+}
+
+func (r *memoryUsers) UpdatePassword(id, passwordHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Password = passwordHash
+	return nil
+}
+
+func (r *memoryUsers) MarkEmailVerified(id, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || user.Email != email {
+		return ErrNotFound
+	}
+	user.EmailVerified = true
+	return nil
+}
+
+func (r *memoryUsers) List(page Page) (*UserPage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	after := 0
+	if page.Cursor != nil {
+		after, _ = strconv.Atoi(page.Cursor.ID)
+	}
+	ids := make([]int, 0, len(r.users))
+	for id := range r.users {
+		numericID, _ := strconv.Atoi(id)
+		if numericID > after {
+			ids = append(ids, numericID)
+		}
+	}
+	sort.Ints(ids)
+
+	rowCount := len(ids)
+	if rowCount > page.Limit {
+		ids = ids[:page.Limit]
+	}
+	result := &UserPage{Users: make([]User, 0, len(ids))}
+	for _, id := range ids {
+		result.Users = append(result.Users, *r.users[strconv.Itoa(id)])
+	}
+	if len(result.Users) > 0 {
+		result.Next = NextCursor(nil, page, rowCount, result.Users[len(result.Users)-1].ID, nil)
+	}
+	return result, nil
+}
+
+func (r *memoryUsers) SetRole(id string, role Role) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Role = role
+	return nil
+}
+
+func (r *memoryUsers) SetDisabled(id string, disabled bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Disabled = disabled
+	return nil
+}
+
+func (r *memoryUsers) SetTOTPSecret(id, secret string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.TOTPSecret = secret
+	user.TOTPEnabled = false
+	return nil
+}
+
+func (r *memoryUsers) EnableTOTP(id string, recoveryCodeHashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || user.TOTPSecret == "" {
+		return ErrNotFound
+	}
+	user.TOTPEnabled = true
+	codes := make(map[string]bool, len(recoveryCodeHashes))
+	for _, codeHash := range recoveryCodeHashes {
+		codes[codeHash] = false
+	}
+	r.recoveryCodes[id] = codes
+	return nil
+}
+
+func (r *memoryUsers) DisableTOTP(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	delete(r.totpSteps, id)
+	delete(r.recoveryCodes, id)
+	return nil
+}
+
+func (r *memoryUsers) UseTOTPStep(id string, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if step <= r.totpSteps[id] {
+		return false, nil
+	}
+	r.totpSteps[id] = step
+	return true, nil
+}
+
+func (r *memoryUsers) UseRecoveryCode(id, codeHash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	used, ok := r.recoveryCodes[id][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	r.recoveryCodes[id][codeHash] = true
+	return true, nil
+}
+
+func (r *memoryUsers) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.users, id)
+	for bookID, book := range r.books {
+		if book.userID == id {
+			delete(r.books, bookID)
+		}
+	}
+	for familyID, family := range r.families {
+		if family.userID == id {
+			delete(r.families, familyID)
+		}
+	}
+	for tokenHash, token := range r.refreshTokens {
+		if _, ok := r.families[token.familyID]; !ok {
+			delete(r.refreshTokens, tokenHash)
+		}
+	}
+	for tokenHash, reset := range r.resets {
+		if reset.userID == id {
+			delete(r.resets, tokenHash)
+		}
+	}
+	delete(r.totpSteps, id)
+	delete(r.recoveryCodes, id)
+	for keyID, key := range r.apiKeys {
+		if key.UserID == id {
+			delete(r.apiKeys, keyID)
+		}
+	}
+	for identity, userID := range r.identities {
+		if userID == id {
+			delete(r.identities, identity)
+		}
+	}
+	return nil
+}
+
+type memoryIdentity struct {
+	issuer  string
+	subject string
+}
+
+func (r *memoryUsers) ByIdentity(issuer, subject string) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, ok := r.identities[memoryIdentity{issuer, subject}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	found := *r.users[id]
+	return &found, nil
+}
+
+func (r *memoryUsers) LinkIdentity(id, issuer, subject string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[id]; !ok {
+		return ErrNotFound
+	}
+	key := memoryIdentity{issuer, subject}
+	if _, ok := r.identities[key]; ok {
+		return fmt.Errorf("identity %s at %s is already linked", subject, issuer)
+	}
+	r.identities[key] = id
+	return nil
+}
+
+type memoryBooks struct {
+	*memoryStore
+}
+
+func (r *memoryBooks) Add(userID string, book *Book) (*Book, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastBookID++
+	stored := &memoryBook{
+		Book:   *book,
+		userID: userID,
+		added:  memoryToday(),
+	}
+	stored.ID = strconv.Itoa(r.lastBookID)
+	stored.DateWhenAdded = stored.added.Format(time.RFC3339Nano)
+	if stored.Status == "" {
+		stored.Status = StatusWantToRead
+	}
+	if stored.Status == StatusFinished {
+		complete := 100
+		stored.FinishedAt = stored.added.Format(filterDateLayout)
+		stored.ProgressPercent = &complete
+	} else {
+		stored.Rating = 0
+		stored.Comment = ""
+	}
+	r.books[r.lastBookID] = stored
+
+	added := stored.view()
+	return &added, nil
+}
+
+func (r *memoryBooks) ByID(userID string, bookID int) (*Book, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	book, ok := r.books[bookID]
+	if !ok || book.userID != userID {
+		return nil, ErrNotFound
+	}
+	found := book.view()
+	return &found, nil
+}
+
+func (r *memoryBooks) MarkFinished(userID string, bookID int, rating int, comment string) (*Book, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	book, ok := r.books[bookID]
+	if !ok || book.userID != userID {
+		return nil, ErrNotFound
+	}
+	if book.Status == StatusFinished {
+		return nil, ErrAlreadyFinished
+	}
+	book.Status = StatusFinished
+	book.Rating = rating
+	book.Comment = comment
+	book.added = memoryToday()
+	book.DateWhenAdded = book.added.Format(time.RFC3339Nano)
+	book.FinishedAt = book.added.Format(filterDateLayout)
+	if book.PageCount != nil {
+		book.CurrentPage, book.ProgressPercent = book.PageCount, nil
+	} else {
+		complete := 100
+		book.CurrentPage, book.ProgressPercent = nil, &complete
+	}
+	finished := book.view()
+	return &finished, nil
+}
+
+func (r *memoryBooks) SetReadingState(userID string, bookID int, from BookStatus, state *ReadingState) (*Book, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	book, ok := r.books[bookID]
+	if !ok || book.userID != userID {
+		return nil, ErrNotFound
+	}
+	if book.Status != from {
+		return nil, ErrStatusChanged
+	}
+	book.Status = state.Status
+	book.PageCount = state.PageCount
+	book.CurrentPage = state.CurrentPage
+	book.ProgressPercent = state.ProgressPercent
+	book.StartedAt = state.StartedAt
+	book.FinishedAt = state.FinishedAt
+	book.Rating = 0
+	if state.Status == StatusFinished && state.Rating != nil {
+		book.Rating = *state.Rating
+	}
+	book.Comment = state.Comment
+	updated := book.view()
+	return &updated, nil
+}
+
+func (r *memoryBooks) Update(userID string, bookID int, patch *BookPatch) (*Book, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	book, ok := r.books[bookID]
+	if !ok || book.userID != userID {
+		return nil, ErrNotFound
+	}
+	if patch.Title != nil {
+		book.Title = *patch.Title
+	}
+	if patch.Author != nil {
+		book.Author = *patch.Author
+	}
+	if patch.CoverImage != nil {
+		book.CoverImage = *patch.CoverImage
+	}
+	if patch.Rating != nil {
+		book.Rating = *patch.Rating
+	}
+	if patch.Comment != nil {
+		book.Comment = *patch.Comment
+	}
+	if patch.PageCount != nil {
+		book.PageCount = patch.PageCount
+	}
+	updated := book.view()
+	return &updated, nil
+}
+
+func (r *memoryBooks) Delete(userID string, bookID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	book, ok := r.books[bookID]
+	if !ok || book.userID != userID {
+		return ErrNotFound
+	}
+	delete(r.books, bookID)
+	return nil
+}
+
+func (r *memoryBooks) List(userID string, status BookStatus, query BookQuery) (*BookPage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var matched []memoryMatch
+	for _, book := range r.books {
+		if book.userID == userID && book.Status == status && memoryFilterMatches(book, query.Filter) {
+			matched = append(matched, memoryMatch{book: book})
+		}
+	}
+
+	rows, next := memoryPaginate(matched, query)
+	page := BookPage{Books: []Book{}, Next: next}
+	for _, row := range rows {
+		page.Books = append(page.Books, row.book.view())
+	}
+	return &page, nil
+}
+
+func (r *memoryBooks) Search(userID, q string, query BookQuery) (*SearchPage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	groups := parseMemoryQuery(q)
+	var matched []memoryMatch
+	for _, book := range r.books {
+		if book.userID != userID || !memoryFilterMatches(book, query.Filter) {
+			continue
+		}
+		document := book.Title + " - " + book.Author + " " + book.Comment
+		words := memoryWords(document)
+		if rank, ok := memoryRank(words, groups); ok {
+			matched = append(matched, memoryMatch{book: book, rank: rank, snippet: memoryHighlight(document, groups)})
+		}
+	}
+
+	rows, next := memoryPaginate(matched, query)
+	page := SearchPage{Results: []SearchResult{}, Next: next}
+	for _, row := range rows {
+		page.Results = append(page.Results, SearchResult{Book: row.book.view(), Rank: row.rank, Snippet: row.snippet})
+	}
+	return &page, nil
+}
+
+type memoryFamily struct {
+	Session
+	userID  string
+	revoked bool
+}
+
+type memoryRefreshToken struct {
+	familyID  string
+	expiresAt time.Time
+	used      bool
+}
+
+type memoryTokens struct {
+	*memoryStore
+}
+
+func (r *memoryTokens) CreateFamily(userID, userAgent, ip string) (string, error) {
+	familyID, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.families[familyID] = &memoryFamily{
+		Session: Session{ID: familyID, UserAgent: userAgent, IP: ip, CreatedAt: now, LastSeenAt: now},
+		userID:  userID,
+	}
+	return familyID, nil
+}
+
+func (r *memoryTokens) AddRefreshToken(familyID, tokenHash string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.families[familyID]; !ok {
+		return ErrNotFound
+	}
+	r.refreshTokens[tokenHash] = &memoryRefreshToken{familyID: familyID, expiresAt: expiresAt}
+	return nil
+}
+
+func (r *memoryTokens) RefreshToken(tokenHash string) (*RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.refreshTokens[tokenHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	family := r.families[token.familyID]
+	return &RefreshToken{
+		FamilyID:  token.familyID,
+		UserID:    family.userID,
+		ExpiresAt: token.expiresAt,
+		Used:      token.used,
+		Revoked:   family.revoked,
+	}, nil
+}
+
+func (r *memoryTokens) UseRefreshToken(tokenHash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.refreshTokens[tokenHash]
+	if !ok || token.used {
+		return false, nil
+	}
+	token.used = true
+	return true, nil
+}
+
+func (r *memoryTokens) RevokeFamily(familyID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if family, ok := r.families[familyID]; ok {
+		family.revoked = true
+	}
+	return nil
+}
+
+func (r *memoryTokens) RevokeUserFamilies(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, family := range r.families {
+		if family.userID == userID {
+			family.revoked = true
+		}
+	}
+	return nil
+}
+
+func (r *memoryTokens) RevokeOtherFamilies(userID, keepFamilyID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for familyID, family := range r.families {
+		if family.userID == userID && familyID != keepFamilyID {
+			family.revoked = true
+		}
+	}
+	return nil
+}
+
+func (r *memoryTokens) IsFamilyActive(familyID string) (string, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	family, ok := r.families[familyID]
+	if !ok {
+		return "", false, nil
+	}
+	return family.userID, !family.revoked, nil
+}
+
+func (r *memoryTokens) TouchFamily(familyID, ip string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if family, ok := r.families[familyID]; ok {
+		family.LastSeenAt = time.Now()
+		family.IP = ip
+	}
+	return nil
+}
+
+func (r *memoryTokens) Sessions(userID string) ([]Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	refreshable := make(map[string]bool)
+	for _, token := range r.refreshTokens {
+		if !token.used && now.Before(token.expiresAt) {
+			refreshable[token.familyID] = true
+		}
+	}
+
+	sessions := []Session{}
+	for familyID, family := range r.families {
+		if family.userID == userID && !family.revoked && refreshable[familyID] {
+			sessions = append(sessions, family.Session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastSeenAt.Equal(sessions[j].LastSeenAt) {
+			return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions, nil
+}
+
+func (r *memoryTokens) RevokeUserFamily(userID, familyID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	family, ok := r.families[familyID]
+	if !ok || family.userID != userID || family.revoked {
+		return ErrNotFound
+	}
+	family.revoked = true
+	return nil
+}
+
+type memoryReset struct {
+	userID    string
+	expiresAt time.Time
+	used      bool
+}
+
+func (r *memoryTokens) AddPasswordReset(userID, tokenHash string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.resets[tokenHash] = &memoryReset{userID: userID, expiresAt: expiresAt}
+	return nil
+}
+
+func (r *memoryTokens) UsePasswordReset(tokenHash string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reset, ok := r.resets[tokenHash]
+	if !ok || reset.used || !time.Now().Before(reset.expiresAt) {
+		return "", ErrNotFound
+	}
+	for _, other := range r.resets {
+		if other.userID == reset.userID {
+			other.used = true
+		}
+	}
+	return reset.userID, nil
+}
+
+type memoryAPIKey struct {
+	APIKey
+	keyHash string
+	revoked bool
+}
+
+type memoryAPIKeys struct {
+	*memoryStore
+}
+
+func (r *memoryAPIKeys) Create(userID, keyHash string, key *APIKey) (*APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastAPIKeyID++
+	stored := &memoryAPIKey{APIKey: *key, keyHash: keyHash}
+	stored.ID = strconv.Itoa(r.lastAPIKeyID)
+	stored.UserID = userID
+	stored.CreatedAt = time.Now()
+	stored.LastUsedAt = nil
+	r.apiKeys[stored.ID] = stored
+
+	created := stored.APIKey
+	return &created, nil
+}
+
+func (r *memoryAPIKeys) List(userID string) ([]APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := []APIKey{}
+	for _, key := range r.apiKeys {
+		if key.UserID == userID && !key.revoked {
+			keys = append(keys, key.APIKey)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, _ := strconv.Atoi(keys[i].ID)
+		b, _ := strconv.Atoi(keys[j].ID)
+		return a > b
+	})
+	return keys, nil
+}
+
+func (r *memoryAPIKeys) ByHash(keyHash string) (*APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, key := range r.apiKeys {
+		if key.keyHash == keyHash && !key.revoked {
+			found := key.APIKey
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryAPIKeys) Revoke(userID, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.apiKeys[id]
+	if !ok || key.UserID != userID || key.revoked {
+		return ErrNotFound
+	}
+	key.revoked = true
+	return nil
+}
+
+func (r *memoryAPIKeys) RevokeUserKeys(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, key := range r.apiKeys {
+		if key.UserID == userID {
+			key.revoked = true
+		}
+	}
+	return nil
+}
+
+func (r *memoryAPIKeys) Touch(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if key, ok := r.apiKeys[id]; ok {
+		now := time.Now()
+		key.LastUsedAt = &now
+	}
+	return nil
+}
+
+type memoryAudit struct {
+	*memoryStore
+}
+
+func (r *memoryAudit) Append(event *AuditEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *event
+	stored.ID = strconv.Itoa(len(r.auditLog) + 1)
+	stored.Time = time.Now()
+	stored.Details = make(map[string]string, len(event.Details))
+	for key, value := range event.Details {
+		stored.Details[key] = value
+	}
+	r.auditLog = append(r.auditLog, stored)
+	return nil
+}
+
+func (r *memoryAudit) List(query AuditQuery, page Page) (*AuditPage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	before := len(r.auditLog) + 1
+	if page.Cursor != nil {
+		if cursorID, err := strconv.Atoi(page.Cursor.Values[0]); err == nil && cursorID < before {
+			before = cursorID
+		}
+	}
+
+	var matches []AuditEvent
+	for i := before - 2; i >= 0 && len(matches) <= page.Limit; i-- {
+		event := r.auditLog[i]
+		if (query.UserID == "" || event.UserID == query.UserID) &&
+			(query.ActorID == "" || event.ActorID == query.ActorID) &&
+			(query.Event == "" || event.Event == query.Event) {
+			matches = append(matches, event)
+		}
+	}
+
+	rowCount := len(matches)
+	if rowCount > page.Limit {
+		matches = matches[:page.Limit]
+	}
+	result := &AuditPage{Events: append([]AuditEvent{}, matches...)}
+	if len(result.Events) > 0 {
+		last := result.Events[len(result.Events)-1].ID
+		result.Next = NextCursor(auditSortKeys, page, rowCount, last, []string{last})
+	}
+	return result, nil
+}
+
+type memoryReadingSession struct {
+	ReadingSession
+	bookID int
+}
+
+type memoryReadingSessions struct {
+	*memoryStore
+}
+
+func (r *memoryReadingSessions) Add(userID string, bookID int, session *ReadingSession) (*ReadingSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	book, ok := r.books[bookID]
+	if !ok || book.userID != userID {
+		return nil, ErrNotFound
+	}
+	stored := memoryReadingSession{ReadingSession: *session, bookID: bookID}
+	stored.ID = strconv.Itoa(len(r.readingSessions) + 1)
+	stored.BookID = strconv.Itoa(bookID)
+	r.readingSessions = append(r.readingSessions, stored)
+
+	added := stored.ReadingSession
+	return &added, nil
+}
+
+func (r *memoryReadingSessions) List(userID string, bookID int, page Page) (*ReadingSessionPage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := &ReadingSessionPage{Sessions: []ReadingSession{}}
+	book, ok := r.books[bookID]
+	if !ok || book.userID != userID {
+		return result, nil
+	}
+
+	before := len(r.readingSessions) + 1
+	if page.Cursor != nil {
+		if cursorID, err := strconv.Atoi(page.Cursor.Values[0]); err == nil && cursorID < before {
+			before = cursorID
+		}
+	}
+
+	var matches []ReadingSession
+	for i := before - 2; i >= 0 && len(matches) <= page.Limit; i-- {
+		if session := r.readingSessions[i]; session.bookID == bookID {
+			matches = append(matches, session.ReadingSession)
+		}
+	}
+
+	rowCount := len(matches)
+	if rowCount > page.Limit {
+		matches = matches[:page.Limit]
+	}
+	result.Sessions = append(result.Sessions, matches...)
+	if len(result.Sessions) > 0 {
+		last := result.Sessions[len(result.Sessions)-1].ID
+		result.Next = NextCursor(readingSessionSortKeys, page, rowCount, last, []string{last})
+	}
+	return result, nil
+}
+
+func (r *memoryReadingSessions) Pace(userID string) (*ReadingPace, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var pace ReadingPace
+	for _, session := range r.readingSessions {
+		book, ok := r.books[session.bookID]
+		if !ok || book.userID != userID || session.PagesRead == 0 {
+			continue
+		}
+		pace.Pages += session.PagesRead
+		pace.Duration += session.EndedAt.Sub(session.StartedAt)
+	}
+	return &pace, nil
+}
+
+// memoryToday mirrors the date-only value PostgreSQL stores in date_added.
+func memoryToday() time.Time {
+	year, month, day := time.Now().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+type memoryMatch struct {
+	book    *memoryBook
+	rank    float64
+	snippet string
+}
+
+// sortValue encodes a field so that plain string comparison orders it.
+func (m memoryMatch) sortValue(field string) string {
+	switch field {
+	case "date_added":
+		return m.book.added.UTC().Format("2006-01-02T15:04:05.000000000")
+	case "rating":
+		return fmt.Sprintf("%03d", m.book.Rating)
+	case "title":
+		return strings.ToLower(m.book.Title)
+	case "author":
+		return strings.ToLower(m.book.Author)
+	case "rank":
+		return fmt.Sprintf("%020.10f", m.rank)
+	}
+	return ""
+}
+
+// compareToKey compares a row with the sort values and id of another row.
+func (m memoryMatch) compareToKey(keys []SortKey, values []string, id int) int {
+	for i, key := range keys {
+		comparison := strings.Compare(m.sortValue(key.Field), values[i])
+		if key.Desc {
+			comparison = -comparison
+		}
+		if comparison != 0 {
+			return comparison
+		}
+	}
+	ownID, _ := strconv.Atoi(m.book.ID)
+	switch {
+	case ownID < id:
+		return -1
+	case ownID > id:
+		return 1
+	}
+	return 0
+}
+
+func (m memoryMatch) sortValues(keys []SortKey) []string {
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = m.sortValue(key.Field)
+	}
+	return values
+}
+
+func memoryPaginate(matched []memoryMatch, query BookQuery) ([]memoryMatch, *Cursor) {
+	sort.Slice(matched, func(i, j int) bool {
+		id, _ := strconv.Atoi(matched[j].book.ID)
+		return matched[i].compareToKey(query.Sort, matched[j].sortValues(query.Sort), id) < 0
+	})
+
+	start := 0
+	if cursor := query.Page.Cursor; cursor != nil {
+		id, _ := strconv.Atoi(cursor.ID)
+		for start < len(matched) && matched[start].compareToKey(query.Sort, cursor.Values, id) <= 0 {
+			start++
+		}
+	}
+	matched = matched[start:]
+
+	rowCount := len(matched)
+	if rowCount > query.Page.Limit {
+		matched = matched[:query.Page.Limit]
+	}
+	if len(matched) == 0 {
+		return matched, nil
+	}
+	last := matched[len(matched)-1]
+	return matched, NextCursor(query.Sort, query.Page, rowCount, last.book.ID, last.sortValues(query.Sort))
+}
+
+func memoryFilterMatches(book *memoryBook, filter BookFilter) bool {
+	if filter.Author != "" && !strings.Contains(strings.ToLower(book.Author), strings.ToLower(filter.Author)) {
+		return false
+	}
+	if filter.Title != "" && !strings.Contains(strings.ToLower(book.Title), strings.ToLower(filter.Title)) {
+		return false
+	}
+	// Only finished books have a rating; 0 stands for NULL in PostgreSQL.
+	if (filter.RatingMin != nil || filter.RatingMax != nil) && (book.Status != StatusFinished || book.Rating == 0) {
+		return false
+	}
+	if filter.RatingMin != nil && book.Rating < *filter.RatingMin {
+		return false
+	}
+	if filter.RatingMax != nil && book.Rating > *filter.RatingMax {
+		return false
+	}
+	if filter.AddedFrom != nil && book.added.Before(*filter.AddedFrom) {
+		return false
+	}
+	if filter.AddedTo != nil && !book.added.Before(filter.AddedTo.AddDate(0, 0, 1)) {
+		return false
+	}
+	if len(filter.Statuses) > 0 {
+		listed := false
+		for _, status := range filter.Statuses {
+			listed = listed || book.Status == status
+		}
+		if !listed {
+			return false
+		}
+	}
+	return true
+}
+
+// memoryTerms is one alternative of a search query: every include term must
+// be present and no exclude term may be.
+type memoryTerms struct {
+	include []string
+	exclude []string
+}
+
+// parseMemoryQuery understands the subset of web search syntax that matters
+// for matching words: "or" between alternatives and -word exclusions.
+func parseMemoryQuery(q string) []memoryTerms {
+	groups := []memoryTerms{{}}
+	for _, field := range strings.Fields(strings.ToLower(q)) {
+		if field == "or" {
+			groups = append(groups, memoryTerms{})
+			continue
+		}
+		group := &groups[len(groups)-1]
+		exclude := strings.HasPrefix(field, "-")
+		for _, word := range memoryWords(strings.TrimPrefix(field, "-")) {
+			if exclude {
+				group.exclude = append(group.exclude, word)
+			} else {
+				group.include = append(group.include, word)
+			}
+		}
+	}
+	return groups
+}
+
+func memoryWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// memoryRank returns the share of document words that match the query.
+func memoryRank(words []string, groups []memoryTerms) (float64, bool) {
+	counts := make(map[string]int)
+	for _, word := range words {
+		counts[word]++
+	}
+
+	best, matched := 0.0, false
+	for _, group := range groups {
+		if len(group.include) == 0 {
+			continue
+		}
+		ok, hits := true, 0
+		for _, term := range group.include {
+			if counts[term] == 0 {
+				ok = false
+				break
+			}
+			hits += counts[term]
+		}
+		for _, term := range group.exclude {
+			if counts[term] > 0 {
+				ok = false
+			}
+		}
+		if ok {
+			matched = true
+			if rank := float64(hits) / float64(len(words)); rank > best {
+				best = rank
+			}
+		}
+	}
+	return best, matched
+}
+
+func memoryHighlight(document string, groups []memoryTerms) string {
+	terms := make(map[string]bool)
+	for _, group := range groups {
+		for _, term := range group.include {
+			terms[term] = true
+		}
+	}
+
+	var snippet strings.Builder
+	var word strings.Builder
+	flush := func() {
+		if word.Len() == 0 {
+			return
+		}
+		if terms[strings.ToLower(word.String())] {
+			snippet.WriteString(snippetStartSel + word.String() + snippetStopSel)
+		} else {
+			snippet.WriteString(word.String())
+		}
+		word.Reset()
+	}
+	for _, r := range document {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			word.WriteRune(r)
+			continue
+		}
+		flush()
+		snippet.WriteRune(r)
+	}
+	flush()
+	return highlightSnippet(snippet.String())
+}
@@ -0,0 +1,238 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"myLibrary/package/logger"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type contextKey int
+
+const callerKey contextKey = iota
+
+// Caller is the authenticated owner of the bearer token of the current request.
+// Callers authenticated with an API key have an APIKeyID and Scopes instead of
+// a FamilyID.
+type Caller struct {
+	ID       string
+	Email    string
+	Role     Role
+	FamilyID string
+	APIKeyID string
+	Scopes   []Scope
+}
+
+// HasScope reports whether the caller may act within scope. Access tokens
+// hold every scope, API keys only the ones they were created with.
+func (c *Caller) HasScope(scope Scope) bool {
+	if c.APIKeyID == "" {
+		return true
+	}
+	for _, granted := range c.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CallerFromContext returns the caller stored by the authentication middleware.
+func CallerFromContext(ctx context.Context) (*Caller, bool) {
+	caller, ok := ctx.Value(callerKey).(*Caller)
+	return caller, ok
+}
+
+// protect is the middleware chain for every /user/:uuid route.
+func (h *handler) protect(scope Scope, next httprouter.Handle) httprouter.Handle {
+	return h.authenticate(h.requireScope(scope, h.ownerOnly(next)))
+}
+
+// authenticate validates the bearer token and resolves the user it was issued to.
+func (h *handler) authenticate(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		tokenString, ok := bearerToken(r)
+		if !ok {
+			unauthorized(w, "Unauthorized: missing bearer token")
+			return
+		}
+		if isAPIKey(tokenString) {
+			h.authenticateAPIKey(w, r, params, tokenString, next)
+			return
+		}
+
+		userID, familyID, err := h.parseAccessToken(tokenString)
+		if err != nil {
+			unauthorized(w, "Unauthorized: "+err.Error())
+			return
+		}
+
+		familyUserID, active, err := h.tokens.IsFamilyActive(familyID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+			logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+			return
+		}
+		if !active {
+			unauthorized(w, "Unauthorized: token revoked")
+			return
+		}
+		if familyUserID != userID {
+			unauthorized(w, "Unauthorized: token does not match its session")
+			return
+		}
+		// Like for API keys, a lost last-seen time does not fail the request.
+		if err := h.tokens.TouchFamily(familyID, h.proxies.ClientIP(r)); err != nil {
+			logger.Log.Info("Could not record use of session: " + err.Error())
+		}
+
+		owner, err := h.users.ByID(userID)
+		if err != nil {
+			if err == ErrNotFound {
+				unauthorized(w, "Unauthorized: token owner no longer exists")
+				return
+			}
+			http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+			logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+			return
+		}
+		if owner.Disabled {
+			http.Error(w, "Forbidden: account is disabled", http.StatusForbidden)
+			logger.Log.Info("Forbidden: disabled user " + owner.ID)
+			return
+		}
+		// The role comes from the database rather than the token claim, so
+		// promotions and demotions apply to tokens that are already issued.
+		caller := &Caller{ID: owner.ID, Email: owner.Email, Role: owner.Role, FamilyID: familyID}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), callerKey, caller)), params)
+	}
+}
+
+// authenticateAPIKey is the API key branch of authenticate.
+func (h *handler) authenticateAPIKey(w http.ResponseWriter, r *http.Request, params httprouter.Params,
+	key string, next httprouter.Handle) {
+	apiKey, err := h.apiKeys.ByHash(hashToken(key))
+	if err != nil {
+		if err == ErrNotFound {
+			unauthorized(w, "Unauthorized: invalid or revoked API key")
+			return
+		}
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+
+	owner, err := h.users.ByID(apiKey.UserID)
+	if err != nil {
+		if err == ErrNotFound {
+			unauthorized(w, "Unauthorized: key owner no longer exists")
+			return
+		}
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+	if owner.Disabled {
+		http.Error(w, "Forbidden: account is disabled", http.StatusForbidden)
+		logger.Log.Info("Forbidden: disabled user " + owner.ID)
+		return
+	}
+
+	// A lost last-used timestamp is not worth failing the request for.
+	if err := h.apiKeys.Touch(apiKey.ID); err != nil {
+		logger.Log.Info("Could not record use of API key " + apiKey.ID + ": " + err.Error())
+	}
+
+	caller := &Caller{ID: owner.ID, Email: owner.Email, Role: owner.Role, APIKeyID: apiKey.ID, Scopes: apiKey.Scopes}
+
+	next(w, r.WithContext(context.WithValue(r.Context(), callerKey, caller)), params)
+}
+
+// requireScope rejects API keys that were not granted scope.
+func (h *handler) requireScope(scope Scope, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		caller, ok := CallerFromContext(r.Context())
+		if !ok {
+			unauthorized(w, "Unauthorized: missing caller")
+			return
+		}
+
+		if !caller.HasScope(scope) {
+			if scope == ScopeSession {
+				http.Error(w, "Forbidden: API keys cannot be used here, log in instead", http.StatusForbidden)
+			} else {
+				http.Error(w, "Forbidden: API key lacks scope "+string(scope), http.StatusForbidden)
+			}
+			logger.Log.Info("Forbidden: API key " + caller.APIKeyID + " lacks scope " + string(scope))
+			return
+		}
+
+		next(w, r, params)
+	}
+}
+
+// require is the middleware chain for routes that need a permission
+// instead of ownership of a path user.
+// Admin routes are never open to API keys.
+func (h *handler) require(permission Permission, next httprouter.Handle) httprouter.Handle {
+	return h.authenticate(h.requireScope(ScopeSession, h.authorize(permission, next)))
+}
+
+// authorize rejects callers whose role does not grant permission.
+func (h *handler) authorize(permission Permission, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		caller, ok := CallerFromContext(r.Context())
+		if !ok {
+			unauthorized(w, "Unauthorized: missing caller")
+			return
+		}
+
+		if !caller.Role.Can(permission) {
+			http.Error(w, "Forbidden: missing permission "+string(permission), http.StatusForbidden)
+			logger.Log.Info("Forbidden: user " + caller.ID + " lacks " + string(permission))
+			return
+		}
+
+		next(w, r, params)
+	}
+}
+
+// ownerOnly rejects requests whose caller does not own the :uuid in the path.
+func (h *handler) ownerOnly(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		caller, ok := CallerFromContext(r.Context())
+		if !ok {
+			unauthorized(w, "Unauthorized: missing caller")
+			return
+		}
+
+		pathID, err := strconv.Atoi(params.ByName("uuid"))
+		callerID, _ := strconv.Atoi(caller.ID)
+		if err != nil || pathID != callerID {
+			http.Error(w, "Forbidden: resource belongs to another user", http.StatusForbidden)
+			logger.Log.Info("Forbidden: user " + caller.ID + " requested user " + params.ByName("uuid"))
+			return
+		}
+
+		next(w, r, params)
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(prefix):]), true
+}
+
+func unauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="myLibrary"`)
+	http.Error(w, message, http.StatusUnauthorized)
+	logger.Log.Info(message)
+}
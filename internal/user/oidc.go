@@ -0,0 +1,297 @@
+package user
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/julienschmidt/httprouter"
+	"myLibrary/internal/oidc"
+	"myLibrary/package/logger"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	OIDCLoginUrl    = "/oidc/login"
+	OIDCCallbackUrl = "/oidc/callback"
+)
+
+// oidcLoginTTL is how long the user has to sign in at the provider.
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcCookie carries the state, nonce and PKCE verifier of a login from
+// OIDCLogin to OIDCCallback, signed as an oidcLoginPurpose token.
+const oidcCookie = "oidc_login"
+
+const oidcLoginPurpose = "oidc_login"
+
+var (
+	errOIDCEmailUnverified = errors.New("the provider has not verified the email of this account")
+	errOIDCNoAccount       = errors.New("no account is linked to this provider account")
+	errOIDCEmailTaken      = errors.New("an account with this email already exists, log in with the password")
+)
+
+// OIDCLogin sends the browser to the provider. It answers 404 while OIDC is
+// not configured.
+func (h *handler) OIDCLogin(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	if h.oidc == nil {
+		http.Error(w, "OIDC login is not configured", http.StatusNotFound)
+		logger.Log.Info("OIDC login is not configured")
+		return
+	}
+
+	var secrets [3]string
+	for i := range secrets {
+		value, err := randomToken()
+		if err != nil {
+			http.Error(w, "Error when generating state: "+err.Error(), http.StatusInternalServerError)
+			logger.Log.Info("Error when generating state: " + err.Error())
+			return
+		}
+		secrets[i] = value
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	authURL, err := h.oidc.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		logger.Log.Error(err.Error())
+		return
+	}
+
+	cookie, err := h.signPurposeToken(oidcLoginPurpose, state, oidcLoginTTL, jwt.MapClaims{
+		"nonce":    nonce,
+		"verifier": verifier,
+	})
+	if err != nil {
+		http.Error(w, "Error when issuing tokens: "+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info("Error when issuing tokens: " + err.Error())
+		return
+	}
+	h.setOIDCCookie(w, cookie, int(oidcLoginTTL.Seconds()))
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback finishes the login the provider redirected back from and
+// responds like /login: with tokens, or with a 2FA challenge.
+func (h *handler) OIDCCallback(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	if h.oidc == nil {
+		http.Error(w, "OIDC login is not configured", http.StatusNotFound)
+		logger.Log.Info("OIDC login is not configured")
+		return
+	}
+
+	query := r.URL.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		unauthorized(w, strings.TrimSpace("Unauthorized: provider answered "+providerError+" "+query.Get("error_description")))
+		return
+	}
+
+	cookie, err := r.Cookie(oidcCookie)
+	if err != nil {
+		http.Error(w, "Bad request: login was not started here or has expired", http.StatusBadRequest)
+		logger.Log.Info("Bad request: OIDC callback without login cookie")
+		return
+	}
+	// The login cookie is good for one callback only.
+	h.setOIDCCookie(w, "", -1)
+
+	claims, err := h.parsePurposeToken(cookie.Value, oidcLoginPurpose)
+	state := query.Get("state")
+	if err != nil || subtle.ConstantTimeCompare([]byte(claims["sub"].(string)), []byte(state)) != 1 {
+		http.Error(w, "Bad request: login was not started here or has expired", http.StatusBadRequest)
+		logger.Log.Info("Bad request: OIDC state mismatch")
+		return
+	}
+	nonce, _ := claims["nonce"].(string)
+	verifier, _ := claims["verifier"].(string)
+
+	rawIDToken, err := h.oidc.Exchange(query.Get("code"), verifier)
+	if err == nil {
+		var identity *oidc.Identity
+		identity, err = h.oidc.VerifyIDToken(rawIDToken, nonce)
+		if err == nil {
+			h.finishOIDCLogin(w, r, identity)
+			return
+		}
+	}
+	if errors.Is(err, oidc.ErrUnavailable) {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		logger.Log.Error(err.Error())
+		return
+	}
+	unauthorized(w, "Unauthorized: "+err.Error())
+}
+
+func (h *handler) finishOIDCLogin(w http.ResponseWriter, r *http.Request, identity *oidc.Identity) {
+	userForToken, err := h.oidcUser(identity)
+	if err != nil {
+		switch err {
+		case errOIDCEmailUnverified, errOIDCNoAccount, errOIDCEmailTaken:
+			http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+			logger.Log.Info("Forbidden: OIDC subject " + identity.Subject + ": " + err.Error())
+			h.auditFailedLogin(r, nil, identity.Email, "oidc: "+err.Error())
+		default:
+			http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+			logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		}
+		return
+	}
+
+	if userForToken.Disabled {
+		http.Error(w, "Forbidden: account is disabled", http.StatusForbidden)
+		logger.Log.Info("Forbidden: disabled user " + userForToken.ID)
+		h.auditFailedLogin(r, userForToken, identity.Email, "disabled")
+		return
+	}
+
+	// The provider vouches for the identity, not for our second factor.
+	if userForToken.TOTPEnabled {
+		h.challengeSecondFactor(w, userForToken, loginMethodOIDC)
+		return
+	}
+
+	h.startSession(w, r, userForToken, loginMethodOIDC)
+}
+
+// oidcUser finds the user identity signs in as. An unknown identity is linked
+// to the user with the same email or, failing that, gets a new user; both only
+// when the provider has verified the email and the config allows it.
+func (h *handler) oidcUser(identity *oidc.Identity) (*User, error) {
+	linked, err := h.users.ByIdentity(identity.Issuer, identity.Subject)
+	if err != ErrNotFound {
+		return linked, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, errOIDCEmailUnverified
+	}
+
+	existing, err := h.users.ByEmail(identity.Email)
+	switch {
+	case err == nil && !h.cfg.OIDC.LinkByEmail:
+		return nil, errOIDCEmailTaken
+	case err == nil:
+		// The provider has proven the address, which is all our own
+		// verification link would do.
+		if !existing.EmailVerified {
+			err = h.users.MarkEmailVerified(existing.ID, existing.Email)
+			if err != nil {
+				return nil, err
+			}
+			existing.EmailVerified = true
+		}
+		err = h.users.LinkIdentity(existing.ID, identity.Issuer, identity.Subject)
+		if err != nil {
+			return nil, err
+		}
+		logger.Log.Info("Linked OIDC subject " + identity.Subject + " to user " + existing.ID)
+		return existing, nil
+	case err != ErrNotFound:
+		return nil, err
+	}
+
+	if !h.cfg.OIDC.AutoProvision {
+		return nil, errOIDCNoAccount
+	}
+	return h.provisionOIDCUser(identity)
+}
+
+// provisionOIDCUser creates the user for a first OIDC login. The password is
+// random and never shown; the user can set one with /password/forgot.
+func (h *handler) provisionOIDCUser(identity *oidc.Identity) (*User, error) {
+	if !UserSuitableForRestrictions(0, 0, len(identity.Email)) {
+		return nil, errOIDCNoAccount
+	}
+
+	username, err := h.freeUsername(identity)
+	if err != nil {
+		return nil, err
+	}
+	password, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := h.users.Create(&User{Username: username, Password: passwordHash, Email: identity.Email})
+	if err != nil {
+		return nil, err
+	}
+	err = h.users.MarkEmailVerified(created.ID, created.Email)
+	if err != nil {
+		return nil, err
+	}
+	err = h.users.LinkIdentity(created.ID, identity.Issuer, identity.Subject)
+	if err != nil {
+		return nil, err
+	}
+	logger.Log.Info("Created user " + created.ID + " for OIDC subject " + identity.Subject)
+
+	return h.users.ByID(created.ID)
+}
+
+// freeUsername derives an unused username from the provider's preferred
+// username or the email, adding a number when it is taken.
+func (h *handler) freeUsername(identity *oidc.Identity) (string, error) {
+	base := identity.PreferredUsername
+	if base == "" {
+		base = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+	base = truncateBytes(strings.TrimSpace(base), 28)
+	if base == "" {
+		base = "user"
+	}
+
+	for i := 1; i <= 100; i++ {
+		candidate := base
+		if i > 1 {
+			candidate = base + strconv.Itoa(i)
+		}
+		taken, err := h.users.IsUsernameEmailTaken(candidate, "", "")
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+	}
+
+	suffix, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	return truncateBytes(base, 20) + "-" + suffix[:8], nil
+}
+
+// truncateBytes cuts s to at most n bytes without splitting a character.
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func (h *handler) setOIDCCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    value,
+		Path:     "/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.cfg.PublicURL, "https://"),
+		// Lax, because the provider sends the browser back with a top-level GET.
+		SameSite: http.SameSiteLaxMode,
+	})
+}
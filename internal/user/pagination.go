@@ -0,0 +1,154 @@
+package user
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// Cursor points just past the last row of a page. It carries the sort values
+// of that row, so the next page is found by a keyset condition instead of an
+// OFFSET and does not shift when books are inserted.
+type Cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+	ID     string   `json:"id"`
+}
+
+type Page struct {
+	Limit  int
+	Cursor *Cursor
+}
+
+// ListResponse is the envelope of every paginated list endpoint.
+type ListResponse struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+func (c *Cursor) Encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func DecodeCursor(encoded string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var cursor Cursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &cursor, nil
+}
+
+// ParsePage reads ?limit= and ?cursor=. A cursor is only valid together with
+// the sort order it was issued for.
+func ParsePage(query url.Values, keys []SortKey) (Page, error) {
+	page := Page{Limit: defaultPageLimit}
+
+	if rawLimit := query.Get("limit"); rawLimit != "" {
+		limit, err := strconv.Atoi(rawLimit)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return page, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		page.Limit = limit
+	}
+
+	if rawCursor := query.Get("cursor"); rawCursor != "" {
+		cursor, err := DecodeCursor(rawCursor)
+		if err != nil {
+			return page, err
+		}
+		if cursor.Sort != sortSignature(keys) || len(cursor.Values) != len(keys) {
+			return page, fmt.Errorf("cursor does not match the requested sort")
+		}
+		page.Cursor = cursor
+	}
+	return page, nil
+}
+
+// PaginatedQuery builds "SELECT <columns>, <sort values> <from> [AND keyset] ORDER BY ... LIMIT n+1".
+// fromWhere must end with a WHERE clause, args are its arguments. One row more
+// than the limit is requested to learn whether there is a next page.
+func PaginatedQuery(columns, fromWhere string, args []interface{}, keys []SortKey, sortColumns map[string]string, page Page) (string, []interface{}) {
+	var query strings.Builder
+	query.WriteString("SELECT " + columns)
+	for _, key := range keys {
+		query.WriteString(", (" + sortColumns[key.Field] + ")::text")
+	}
+	query.WriteString(" " + fromWhere)
+
+	if page.Cursor != nil {
+		var alternatives []string
+		var equal []string
+		for i, key := range keys {
+			column := sortColumns[key.Field]
+			operator := ">"
+			if key.Desc {
+				operator = "<"
+			}
+			args = append(args, page.Cursor.Values[i])
+			placeholder := "$" + strconv.Itoa(len(args))
+			alternatives = append(alternatives, "("+strings.Join(append(equal[:len(equal):len(equal)], column+" "+operator+" "+placeholder), " AND ")+")")
+			equal = append(equal, column+" = "+placeholder)
+		}
+		args = append(args, page.Cursor.ID)
+		alternatives = append(alternatives, "("+strings.Join(append(equal, "id > $"+strconv.Itoa(len(args))), " AND ")+")")
+		query.WriteString(" AND (" + strings.Join(alternatives, " OR ") + ")")
+	}
+
+	query.WriteString(" " + OrderBy(keys, sortColumns))
+	query.WriteString(" LIMIT " + strconv.Itoa(page.Limit+1))
+	return query.String(), args
+}
+
+// NextCursor returns the cursor after the last returned row, or nil if the
+// query produced no more rows than the limit.
+func NextCursor(keys []SortKey, page Page, rowCount int, lastID string, lastValues []string) *Cursor {
+	if rowCount <= page.Limit {
+		return nil
+	}
+	return &Cursor{Sort: sortSignature(keys), Values: lastValues, ID: lastID}
+}
+
+// WriteList encodes a page of items and advertises the next page in both the
+// envelope and a Link header.
+func WriteList(w http.ResponseWriter, r *http.Request, items interface{}, page Page, next *Cursor) error {
+	response := ListResponse{Items: items}
+	if next != nil {
+		response.NextCursor = next.Encode()
+
+		nextURL := *r.URL
+		query := nextURL.Query()
+		query.Set("cursor", response.NextCursor)
+		query.Set("limit", strconv.Itoa(page.Limit))
+		nextURL.RawQuery = query.Encode()
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextURL.RequestURI()))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(response)
+}
+
+func sortSignature(keys []SortKey) string {
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		direction := "asc"
+		if key.Desc {
+			direction = "desc"
+		}
+		parts = append(parts, key.Field+":"+direction)
+	}
+	return strings.Join(parts, ",")
+}
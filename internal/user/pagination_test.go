@@ -0,0 +1,81 @@
+package user
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	cursor := &Cursor{Sort: "rating:desc,title:asc", Values: []string{"7", "Dune, \"the\" novel"}, ID: "42"}
+	decoded, err := DecodeCursor(cursor.Encode())
+	if err != nil || !reflect.DeepEqual(decoded, cursor) {
+		t.Errorf("round trip: %+v, %v, want %+v", decoded, err, cursor)
+	}
+
+	for _, encoded := range []string{"not base64!", "bm90IGpzb24"} {
+		if cursor, err := DecodeCursor(encoded); err == nil {
+			t.Errorf("DecodeCursor(%q) = %+v, want an error", encoded, cursor)
+		}
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	keys := []SortKey{{Field: "rating", Desc: true}}
+	cursor := (&Cursor{Sort: "rating:desc", Values: []string{"7"}, ID: "42"}).Encode()
+
+	page, err := ParsePage(url.Values{}, keys)
+	if err != nil || page.Limit != defaultPageLimit || page.Cursor != nil {
+		t.Errorf("no parameters: %+v, %v", page, err)
+	}
+	page, err = ParsePage(url.Values{"limit": {"10"}, "cursor": {cursor}}, keys)
+	if err != nil || page.Limit != 10 || page.Cursor == nil || page.Cursor.ID != "42" {
+		t.Errorf("limit and cursor: %+v, %v", page, err)
+	}
+
+	for _, query := range []url.Values{
+		{"limit": {"0"}},
+		{"limit": {"201"}},
+		{"limit": {"ten"}},
+		{"cursor": {"garbage"}},
+		{"cursor": {(&Cursor{Sort: "rating:asc", Values: []string{"7"}, ID: "42"}).Encode()}},
+		{"cursor": {(&Cursor{Sort: "rating:desc", ID: "42"}).Encode()}},
+	} {
+		if page, err := ParsePage(query, keys); err == nil {
+			t.Errorf("ParsePage(%v) = %+v, want an error", query, page)
+		}
+	}
+}
+
+func TestPaginatedQuery(t *testing.T) {
+	keys := []SortKey{{Field: "rating", Desc: true}, {Field: "title"}}
+	args := []interface{}{"user"}
+
+	statement, got := PaginatedQuery("id", "FROM books WHERE user_id = $1", args, keys, finishedSortColumns, Page{Limit: 10})
+	want := "SELECT id, (COALESCE(rating, 0))::text, (lower(title))::text FROM books WHERE user_id = $1 " +
+		"ORDER BY COALESCE(rating, 0) DESC, lower(title) ASC, id ASC LIMIT 11"
+	if statement != want || len(got) != 1 {
+		t.Errorf("first page:\n%s\n%v", statement, got)
+	}
+
+	page := Page{Limit: 10, Cursor: &Cursor{Values: []string{"7", "dune"}, ID: "42"}}
+	statement, got = PaginatedQuery("id", "FROM books WHERE user_id = $1", args, keys, finishedSortColumns, page)
+	keyset := " AND ((COALESCE(rating, 0) < $2) OR (COALESCE(rating, 0) = $2 AND lower(title) > $3) OR " +
+		"(COALESCE(rating, 0) = $2 AND lower(title) = $3 AND id > $4)) "
+	if !strings.Contains(statement, keyset) || !reflect.DeepEqual(got, []interface{}{"user", "7", "dune", "42"}) {
+		t.Errorf("next page:\n%s\n%v", statement, got)
+	}
+}
+
+func TestNextCursor(t *testing.T) {
+	keys := []SortKey{{Field: "title"}}
+	page := Page{Limit: 2}
+	if cursor := NextCursor(keys, page, 2, "2", []string{"b"}); cursor != nil {
+		t.Errorf("a full last page has a next cursor: %+v", cursor)
+	}
+	want := &Cursor{Sort: "title:asc", Values: []string{"b"}, ID: "2"}
+	if cursor := NextCursor(keys, page, 3, "2", []string{"b"}); !reflect.DeepEqual(cursor, want) {
+		t.Errorf("next cursor %+v, want %+v", cursor, want)
+	}
+}
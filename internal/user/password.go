@@ -0,0 +1,100 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"strings"
+	"sync"
+)
+
+// Argon2id parameters for newly hashed passwords. Hashes made with other
+// parameters still verify, but are rehashed on the next successful login.
+const (
+	argonTime    uint32 = 1
+	argonMemory  uint32 = 64 * 1024
+	argonThreads uint8  = 4
+	argonKeyLen  uint32 = 32
+	argonSaltLen        = 16
+)
+
+// Bounds on the parameters of a stored hash. argon2.IDKey panics on zero time
+// or threads, and a corrupt row must not make a login run for minutes or
+// allocate gigabytes.
+const (
+	maxArgonTime   uint32 = 16
+	maxArgonMemory uint32 = 1024 * 1024
+	minArgonKeyLen        = 16
+	maxArgonKeyLen        = 64
+)
+
+const argonPrefix = "$argon2id$"
+
+// HashPassword returns an encoded argon2id hash of password with a random salt.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argonPrefix, argon2.Version,
+		argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifyPassword compares password with the stored value in constant time.
+// Rows created before hashing was introduced hold the plaintext password;
+// they still verify, and needsRehash reports that the row should be upgraded.
+func VerifyPassword(stored, password string) (ok bool, needsRehash bool) {
+	if !strings.HasPrefix(stored, argonPrefix) {
+		ok = subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+		return ok, ok
+	}
+
+	var version int
+	var memory, time uint32
+	var threads uint8
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return false, false
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, false
+	}
+	if time < 1 || time > maxArgonTime || threads < 1 || memory < 8*uint32(threads) || memory > maxArgonMemory {
+		return false, false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) < minArgonKeyLen || len(key) > maxArgonKeyLen {
+		return false, false
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, computed) != 1 {
+		return false, false
+	}
+	needsRehash = memory != argonMemory || time != argonTime || threads != argonThreads || uint32(len(key)) != argonKeyLen
+	return true, needsRehash
+}
+
+var dummyHash struct {
+	once sync.Once
+	hash string
+}
+
+// dummyPasswordHash is verified against when a login names an unknown user.
+func dummyPasswordHash() string {
+	dummyHash.once.Do(func() {
+		dummyHash.hash, _ = HashPassword("dummy password")
+	})
+	return dummyHash.hash
+}
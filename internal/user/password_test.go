@@ -0,0 +1,56 @@
+package user
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, rehash := VerifyPassword(hash, "correct horse"); !ok || rehash {
+		t.Errorf("own hash: got %v, %v", ok, rehash)
+	}
+	if ok, _ := VerifyPassword(hash, "wrong horse"); ok {
+		t.Error("wrong password accepted")
+	}
+
+	// Rows from before hashing hold the plaintext.
+	if ok, rehash := VerifyPassword("plain", "plain"); !ok || !rehash {
+		t.Errorf("legacy row: got %v, %v", ok, rehash)
+	}
+	if ok, rehash := VerifyPassword("plain", "other"); ok || rehash {
+		t.Errorf("legacy row, wrong password: got %v, %v", ok, rehash)
+	}
+
+	older := strings.Replace(hash, "t=1,", "t=2,", 1)
+	if ok, _ := VerifyPassword(older, "correct horse"); ok {
+		t.Error("hash accepted with changed parameters")
+	}
+}
+
+func TestVerifyPasswordMalformed(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(hash, "$")
+	salt, key := parts[4], parts[5]
+
+	for name, stored := range map[string]string{
+		"zero time":       "$argon2id$v=19$m=65536,t=0,p=4$" + salt + "$" + key,
+		"zero threads":    "$argon2id$v=19$m=65536,t=1,p=0$" + salt + "$" + key,
+		"huge memory":     "$argon2id$v=19$m=4294967295,t=1,p=4$" + salt + "$" + key,
+		"huge time":       "$argon2id$v=19$m=65536,t=100000,p=4$" + salt + "$" + key,
+		"empty key":       "$argon2id$v=19$m=65536,t=1,p=4$" + salt + "$",
+		"truncated":       "$argon2id$v=19$m=65536,t=1,p=4$" + salt,
+		"bad version":     "$argon2id$v=16$m=65536,t=1,p=4$" + salt + "$" + key,
+		"bad salt base64": "$argon2id$v=19$m=65536,t=1,p=4$!!$" + key,
+	} {
+		if ok, rehash := VerifyPassword(stored, "correct horse"); ok || rehash {
+			t.Errorf("%s: got %v, %v", name, ok, rehash)
+		}
+	}
+}
@@ -0,0 +1,852 @@
+package user
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// NewPostgresRepositories returns repositories backed by the PostgreSQL pool.
+func NewPostgresRepositories(db *sql.DB) Repositories {
+	return Repositories{
+		Users:           &postgresUsers{db},
+		Books:           &postgresBooks{db},
+		Tokens:          &postgresTokens{db},
+		APIKeys:         &postgresAPIKeys{db},
+		Audit:           &postgresAudit{db},
+		ReadingSessions: &postgresReadingSessions{db},
+	}
+}
+
+type postgresUsers struct {
+	db *sql.DB
+}
+
+const userColumns = "user_id, username, password, email, email_verified_at IS NOT NULL, role, disabled_at IS NOT NULL, " +
+	"totp_enabled_at IS NOT NULL, COALESCE(totp_secret, '')"
+
+func (r *postgresUsers) Create(user *User) (*User, error) {
+	created := User{Username: user.Username, Email: user.Email, Role: RoleUser}
+	err := r.db.QueryRow("INSERT INTO users (username, password, email) VALUES ($1, $2, $3) RETURNING user_id",
+		user.Username, user.Password, user.Email).Scan(&created.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (r *postgresUsers) ByID(id string) (*User, error) {
+	if _, err := strconv.Atoi(id); err != nil {
+		return nil, ErrNotFound
+	}
+	return r.scanUser(r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE user_id = $1", id))
+}
+
+func (r *postgresUsers) ByEmail(email string) (*User, error) {
+	return r.scanUser(r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE email = $1", email))
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (r *postgresUsers) scanUser(row rowScanner) (*User, error) {
+	var user User
+	err := row.Scan(&user.ID, &user.Username, &user.Password, &user.Email, &user.EmailVerified, &user.Role, &user.Disabled,
+		&user.TOTPEnabled, &user.TOTPSecret)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *postgresUsers) IsUsernameEmailTaken(username, email, exceptID string) (bool, error) {
+	if exceptID == "" {
+		exceptID = "0"
+	}
+	var count int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM users WHERE (username = $1 OR email = $2) AND user_id <> $3",
+		username, email, exceptID).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *postgresUsers) Update(user *User) error {
+	_, err := r.db.Exec(`
+		UPDATE users SET username = $1, password = $2, email = $3,
+			email_verified_at = CASE WHEN email = $3 THEN email_verified_at END
+		WHERE user_id = $4
+		`, user.Username, user.Password, user.Email, user.ID)
+	return err
+}
+
+func (r *postgresUsers) UpdatePassword(id, passwordHash string) error {
+	_, err := r.db.Exec("UPDATE users SET password = $1 WHERE user_id = $2", passwordHash, id)
+	return err
+}
+
+func (r *postgresUsers) MarkEmailVerified(id, email string) error {
+	result, err := r.db.Exec("UPDATE users SET email_verified_at = COALESCE(email_verified_at, now()) WHERE user_id = $1 AND email = $2",
+		id, email)
+	return affectedOne(result, err)
+}
+
+func (r *postgresUsers) List(page Page) (*UserPage, error) {
+	query, args := PaginatedQuery(userColumns, "FROM (SELECT *, user_id AS id FROM users) u WHERE TRUE",
+		nil, nil, nil, page)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &UserPage{Users: []User{}}
+	rowCount := 0
+	for rows.Next() {
+		rowCount++
+		user, err := r.scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		if rowCount <= page.Limit {
+			result.Users = append(result.Users, *user)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(result.Users) > 0 {
+		result.Next = NextCursor(nil, page, rowCount, result.Users[len(result.Users)-1].ID, nil)
+	}
+	return result, nil
+}
+
+func (r *postgresUsers) SetRole(id string, role Role) error {
+	result, err := r.db.Exec("UPDATE users SET role = $1 WHERE user_id = $2", role, id)
+	return affectedOne(result, err)
+}
+
+func (r *postgresUsers) SetDisabled(id string, disabled bool) error {
+	result, err := r.db.Exec("UPDATE users SET disabled_at = CASE WHEN $1 THEN COALESCE(disabled_at, now()) END WHERE user_id = $2",
+		disabled, id)
+	return affectedOne(result, err)
+}
+
+func (r *postgresUsers) SetTOTPSecret(id, secret string) error {
+	result, err := r.db.Exec("UPDATE users SET totp_secret = $1, totp_enabled_at = NULL WHERE user_id = $2", secret, id)
+	return affectedOne(result, err)
+}
+
+func (r *postgresUsers) EnableTOTP(id string, recoveryCodeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE users SET totp_enabled_at = now() WHERE user_id = $1 AND totp_secret IS NOT NULL", id)
+	if err := affectedOne(result, err); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", id); err != nil {
+		return err
+	}
+	for _, codeHash := range recoveryCodeHashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", id, codeHash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *postgresUsers) DisableTOTP(id string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE user_id = $1", id)
+	if err := affectedOne(result, err); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *postgresUsers) UseTOTPStep(id string, step int64) (bool, error) {
+	result, err := r.db.Exec("UPDATE users SET totp_last_step = $1 WHERE user_id = $2 AND totp_last_step < $1", step, id)
+	if err := affectedOne(result, err); err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *postgresUsers) UseRecoveryCode(id, codeHash string) (bool, error) {
+	result, err := r.db.Exec("UPDATE recovery_codes SET used_at = now() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL",
+		id, codeHash)
+	if err := affectedOne(result, err); err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *postgresUsers) ByIdentity(issuer, subject string) (*User, error) {
+	return r.scanUser(r.db.QueryRow(`
+		SELECT `+userColumns+` FROM users
+		WHERE user_id = (SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2)
+		`, issuer, subject))
+}
+
+func (r *postgresUsers) LinkIdentity(id, issuer, subject string) error {
+	_, err := r.db.Exec("INSERT INTO user_identities (issuer, subject, user_id) VALUES ($1, $2, $3)",
+		issuer, subject, id)
+	return err
+}
+
+func (r *postgresUsers) Delete(id string) error {
+	result, err := r.db.Exec("DELETE FROM users WHERE user_id = $1", id)
+	return affectedOne(result, err)
+}
+
+type postgresBooks struct {
+	db *sql.DB
+}
+
+// bookColumns are read with bookDest. A book read by page gets its percentage
+// derived from the page count. Books without a rating have NULL, read as 0.
+const bookColumns = "id, title, author, cover_image_url, date_added, COALESCE(rating, 0), COALESCE(comment, ''), " +
+	"status, page_count, current_page, COALESCE(progress_percent, LEAST(100, current_page * 100 / page_count)), " +
+	"COALESCE(to_char(started_at, 'YYYY-MM-DD'), ''), COALESCE(to_char(finished_at, 'YYYY-MM-DD'), '')"
+
+// bookDest is where a row of bookColumns is scanned to.
+func bookDest(book *Book) []interface{} {
+	return []interface{}{&book.ID, &book.Title, &book.Author, &book.CoverImage, &book.DateWhenAdded,
+		&book.Rating, &book.Comment, &book.Status, &book.PageCount, &book.CurrentPage, &book.ProgressPercent,
+		&book.StartedAt, &book.FinishedAt}
+}
+
+func (r *postgresBooks) Add(userID string, book *Book) (*Book, error) {
+	date := today()
+
+	var id string
+	var err error
+	if book.Status == StatusFinished {
+		err = r.db.QueryRow(`
+		INSERT INTO books (title, author, date_added, user_id, status, rating, comment, cover_image_url, finished_at, progress_percent)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6::integer, 0), COALESCE($7, ''), COALESCE($8, ''), $3::date, 100)
+		RETURNING id
+		`, book.Title, book.Author, date, userID, StatusFinished, book.Rating, book.Comment, book.CoverImage).Scan(&id)
+	} else {
+		err = r.db.QueryRow(`
+		INSERT INTO books (title, author, date_added, user_id, status, cover_image_url, started_at)
+		VALUES ($1, $2, $3, $4, $5, COALESCE($6, ''), NULLIF($7, '')::date)
+		RETURNING id
+		`, book.Title, book.Author, date, userID, book.Status, book.CoverImage, book.StartedAt).Scan(&id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bookID, _ := strconv.Atoi(id)
+	return r.ByID(userID, bookID)
+}
+
+func (r *postgresBooks) ByID(userID string, bookID int) (*Book, error) {
+	var book Book
+	err := r.db.QueryRow("SELECT "+bookColumns+" FROM books WHERE id = $1 AND user_id = $2", bookID, userID).
+		Scan(bookDest(&book)...)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+func (r *postgresBooks) MarkFinished(userID string, bookID int, rating int, comment string) (*Book, error) {
+	result, err := r.db.Exec(`
+		UPDATE books SET
+			status = $1, comment = $2, rating = NULLIF($3::integer, 0), date_added = $4, finished_at = $4::date,
+			current_page = page_count,
+			progress_percent = CASE WHEN page_count IS NULL THEN 100 END
+		WHERE id = $5 AND user_id = $6 AND status <> $1
+		`, StatusFinished, comment, rating, today(), bookID, userID)
+	if err := affectedOne(result, err); err == ErrNotFound {
+		if _, err := r.ByID(userID, bookID); err != nil {
+			return nil, err
+		}
+		return nil, ErrAlreadyFinished
+	} else if err != nil {
+		return nil, err
+	}
+	return r.ByID(userID, bookID)
+}
+
+func (r *postgresBooks) SetReadingState(userID string, bookID int, from BookStatus, state *ReadingState) (*Book, error) {
+	result, err := r.db.Exec(`
+		UPDATE books SET
+			status = $1,
+			page_count = $2,
+			current_page = $3,
+			progress_percent = $4,
+			started_at = NULLIF($5, '')::date,
+			finished_at = NULLIF($6, '')::date,
+			rating = CASE WHEN $1 = 'finished' THEN $7::integer END,
+			comment = $8
+		WHERE id = $9 AND user_id = $10 AND status = $11
+		`, state.Status, state.PageCount, state.CurrentPage, state.ProgressPercent, state.StartedAt, state.FinishedAt,
+		state.Rating, state.Comment, bookID, userID, from)
+	if err := affectedOne(result, err); err == ErrNotFound {
+		if _, err := r.ByID(userID, bookID); err != nil {
+			return nil, err
+		}
+		return nil, ErrStatusChanged
+	} else if err != nil {
+		return nil, err
+	}
+	return r.ByID(userID, bookID)
+}
+
+func (r *postgresBooks) Update(userID string, bookID int, patch *BookPatch) (*Book, error) {
+	result, err := r.db.Exec(`
+		UPDATE books SET
+			title = COALESCE($1, title),
+			author = COALESCE($2, author),
+			cover_image_url = COALESCE($3, cover_image_url),
+			rating = COALESCE($4, rating),
+			comment = COALESCE($5, comment),
+			page_count = COALESCE($6, page_count)
+		WHERE id = $7 AND user_id = $8
+		`, patch.Title, patch.Author, patch.CoverImage, patch.Rating, patch.Comment, patch.PageCount, bookID, userID)
+	if err := affectedOne(result, err); err != nil {
+		return nil, err
+	}
+	return r.ByID(userID, bookID)
+}
+
+func (r *postgresBooks) Delete(userID string, bookID int) error {
+	result, err := r.db.Exec("DELETE FROM books WHERE id = $1 AND user_id = $2", bookID, userID)
+	return affectedOne(result, err)
+}
+
+func (r *postgresBooks) List(userID string, status BookStatus, query BookQuery) (*BookPage, error) {
+	sortColumns := wishlistSortColumns
+	if status == StatusFinished {
+		sortColumns = finishedSortColumns
+	}
+
+	conditions, args := query.Filter.Where([]interface{}{userID, status})
+	statement, args := PaginatedQuery(bookColumns,
+		"FROM books WHERE user_id = $1 AND status = $2"+conditions, args,
+		query.Sort, sortColumns, query.Page)
+	rows, err := r.db.Query(statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := BookPage{Books: []Book{}}
+	var lastSortValues []string
+	rowCount := 0
+	for rows.Next() {
+		rowCount++
+		var book Book
+		sortValues := make([]string, len(query.Sort))
+		dest := bookDest(&book)
+		for i := range sortValues {
+			dest = append(dest, &sortValues[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if rowCount <= query.Page.Limit {
+			page.Books = append(page.Books, book)
+			lastSortValues = sortValues
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Books) > 0 {
+		page.Next = NextCursor(query.Sort, query.Page, rowCount, page.Books[len(page.Books)-1].ID, lastSortValues)
+	}
+	return &page, nil
+}
+
+// bookDocument is the text a book is searched by. The 'simple' configuration
+// does no stemming, so titles and notes in any language match the same way.
+// It must stay identical to the books_search_idx expression.
+const bookDocument = "to_tsvector('simple', title || ' ' || author || ' ' || COALESCE(comment, ''))"
+
+const snippetOptions = "StartSel=" + snippetStartSel + ", StopSel=" + snippetStopSel + ", MaxFragments=2, MaxWords=20, MinWords=5"
+
+func (r *postgresBooks) Search(userID, q string, query BookQuery) (*SearchPage, error) {
+	conditions, args := query.Filter.Where([]interface{}{userID})
+	args = append(args, q)
+
+	// The matches are computed in a subquery so that rank can be sorted and
+	// paginated on like a plain column.
+	matches := "SELECT id, title, author, cover_image_url, date_added, COALESCE(rating, 0) AS rating, " +
+		"COALESCE(comment, '') AS comment, status, page_count, current_page, " +
+		"COALESCE(progress_percent, LEAST(100, current_page * 100 / page_count)) AS progress_percent, " +
+		"COALESCE(to_char(started_at, 'YYYY-MM-DD'), '') AS started_at, " +
+		"COALESCE(to_char(finished_at, 'YYYY-MM-DD'), '') AS finished_at, ts_rank(" + bookDocument + ", query) AS rank, " +
+		"ts_headline('simple', title || ' - ' || author || ' ' || COALESCE(comment, ''), query, '" + snippetOptions + "') AS snippet " +
+		"FROM books, websearch_to_tsquery('simple', $" + strconv.Itoa(len(args)) + ") AS query " +
+		"WHERE user_id = $1" + conditions + " AND " + bookDocument + " @@ query"
+
+	statement, args := PaginatedQuery("id, title, author, cover_image_url, date_added, rating, comment, status, page_count, "+
+		"current_page, progress_percent, started_at, finished_at, rank, snippet",
+		"FROM ("+matches+") AS matches WHERE true", args,
+		query.Sort, searchSortColumns, query.Page)
+	rows, err := r.db.Query(statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := SearchPage{Results: []SearchResult{}}
+	var lastSortValues []string
+	rowCount := 0
+	for rows.Next() {
+		rowCount++
+		var result SearchResult
+		sortValues := make([]string, len(query.Sort))
+		dest := append(bookDest(&result.Book), &result.Rank, &result.Snippet)
+		for i := range sortValues {
+			dest = append(dest, &sortValues[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		result.Snippet = highlightSnippet(result.Snippet)
+		if rowCount <= query.Page.Limit {
+			page.Results = append(page.Results, result)
+			lastSortValues = sortValues
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Results) > 0 {
+		page.Next = NextCursor(query.Sort, query.Page, rowCount, page.Results[len(page.Results)-1].ID, lastSortValues)
+	}
+	return &page, nil
+}
+
+type postgresTokens struct {
+	db *sql.DB
+}
+
+func (r *postgresTokens) CreateFamily(userID, userAgent, ip string) (string, error) {
+	familyID, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = r.db.Exec("INSERT INTO token_families (id, user_id, user_agent, ip) VALUES ($1, $2, $3, $4)",
+		familyID, userID, userAgent, ip)
+	if err != nil {
+		return "", err
+	}
+	return familyID, nil
+}
+
+func (r *postgresTokens) AddRefreshToken(familyID, tokenHash string, expiresAt time.Time) error {
+	_, err := r.db.Exec("INSERT INTO refresh_tokens (token_hash, family_id, expires_at) VALUES ($1, $2, $3)",
+		tokenHash, familyID, expiresAt)
+	return err
+}
+
+func (r *postgresTokens) RefreshToken(tokenHash string) (*RefreshToken, error) {
+	var token RefreshToken
+	err := r.db.QueryRow(`
+		SELECT t.family_id, f.user_id, t.expires_at, t.used_at IS NOT NULL, f.revoked_at IS NOT NULL
+		FROM refresh_tokens t JOIN token_families f ON f.id = t.family_id
+		WHERE t.token_hash = $1
+		`, tokenHash).Scan(&token.FamilyID, &token.UserID, &token.ExpiresAt, &token.Used, &token.Revoked)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *postgresTokens) UseRefreshToken(tokenHash string) (bool, error) {
+	result, err := r.db.Exec("UPDATE refresh_tokens SET used_at = now() WHERE token_hash = $1 AND used_at IS NULL", tokenHash)
+	if err := affectedOne(result, err); err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *postgresTokens) RevokeFamily(familyID string) error {
+	_, err := r.db.Exec("UPDATE token_families SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL", familyID)
+	return err
+}
+
+func (r *postgresTokens) RevokeUserFamilies(userID string) error {
+	_, err := r.db.Exec("UPDATE token_families SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	return err
+}
+
+func (r *postgresTokens) RevokeOtherFamilies(userID, keepFamilyID string) error {
+	_, err := r.db.Exec("UPDATE token_families SET revoked_at = now() WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL",
+		userID, keepFamilyID)
+	return err
+}
+
+func (r *postgresTokens) IsFamilyActive(familyID string) (string, bool, error) {
+	var userID string
+	var active bool
+	err := r.db.QueryRow("SELECT user_id, revoked_at IS NULL FROM token_families WHERE id = $1", familyID).
+		Scan(&userID, &active)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return userID, active, err
+}
+
+func (r *postgresTokens) TouchFamily(familyID, ip string) error {
+	// As with API keys, a minute is precise enough and spares most requests an UPDATE.
+	_, err := r.db.Exec(`
+		UPDATE token_families SET last_seen_at = now(), ip = $2
+		WHERE id = $1 AND (last_seen_at < now() - interval '1 minute' OR ip <> $2)
+		`, familyID, ip)
+	return err
+}
+
+func (r *postgresTokens) Sessions(userID string) ([]Session, error) {
+	rows, err := r.db.Query(`
+		SELECT f.id, f.user_agent, f.ip, f.created_at, f.last_seen_at
+		FROM token_families f
+		WHERE f.user_id = $1 AND f.revoked_at IS NULL AND EXISTS (
+			SELECT 1 FROM refresh_tokens t
+			WHERE t.family_id = f.id AND t.used_at IS NULL AND t.expires_at > now()
+		)
+		ORDER BY f.last_seen_at DESC, f.id
+		`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(&session.ID, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeenAt)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (r *postgresTokens) RevokeUserFamily(userID, familyID string) error {
+	result, err := r.db.Exec("UPDATE token_families SET revoked_at = now() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL",
+		familyID, userID)
+	return affectedOne(result, err)
+}
+
+func (r *postgresTokens) AddPasswordReset(userID, tokenHash string, expiresAt time.Time) error {
+	_, err := r.db.Exec("INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES ($1, $2, $3)",
+		tokenHash, userID, expiresAt)
+	return err
+}
+
+func (r *postgresTokens) UsePasswordReset(tokenHash string) (string, error) {
+	var userID string
+	err := r.db.QueryRow(`
+		UPDATE password_resets SET used_at = now()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id
+		`, tokenHash).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	_, err = r.db.Exec("UPDATE password_resets SET used_at = now() WHERE user_id = $1 AND used_at IS NULL", userID)
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+type postgresAPIKeys struct {
+	db *sql.DB
+}
+
+const apiKeyColumns = "id, user_id, name, prefix, scopes, created_at, last_used_at"
+
+func (r *postgresAPIKeys) Create(userID, keyHash string, key *APIKey) (*APIKey, error) {
+	var id string
+	err := r.db.QueryRow("INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		userID, key.Name, key.Prefix, keyHash, joinScopes(key.Scopes)).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return r.scanKey(r.db.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE id = $1", id))
+}
+
+func (r *postgresAPIKeys) List(userID string) ([]APIKey, error) {
+	rows, err := r.db.Query("SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = $1 AND revoked_at IS NULL ORDER BY id DESC",
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := r.scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+func (r *postgresAPIKeys) ByHash(keyHash string) (*APIKey, error) {
+	return r.scanKey(r.db.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = $1 AND revoked_at IS NULL",
+		keyHash))
+}
+
+func (r *postgresAPIKeys) scanKey(row rowScanner) (*APIKey, error) {
+	var key APIKey
+	var scopes string
+	var lastUsed sql.NullTime
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &scopes, &key.CreatedAt, &lastUsed)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = splitScopes(scopes)
+	if lastUsed.Valid {
+		key.LastUsedAt = &lastUsed.Time
+	}
+	return &key, nil
+}
+
+func (r *postgresAPIKeys) Revoke(userID, id string) error {
+	if _, err := strconv.Atoi(id); err != nil {
+		return ErrNotFound
+	}
+	result, err := r.db.Exec("UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL",
+		id, userID)
+	return affectedOne(result, err)
+}
+
+func (r *postgresAPIKeys) RevokeUserKeys(userID string) error {
+	_, err := r.db.Exec("UPDATE api_keys SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	return err
+}
+
+func (r *postgresAPIKeys) Touch(id string) error {
+	// Writing once a minute is precise enough and keeps busy scripts from
+	// turning every request into an UPDATE.
+	_, err := r.db.Exec(`
+		UPDATE api_keys SET last_used_at = now()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')
+		`, id)
+	return err
+}
+
+type postgresAudit struct {
+	db *sql.DB
+}
+
+const auditColumns = "id, occurred_at, event, COALESCE(user_id::text, ''), COALESCE(actor_id::text, ''), ip, user_agent, details::text"
+
+func (r *postgresAudit) Append(event *AuditEvent) error {
+	details := []byte("{}")
+	if len(event.Details) > 0 {
+		var err error
+		if details, err = json.Marshal(event.Details); err != nil {
+			return err
+		}
+	}
+	_, err := r.db.Exec("INSERT INTO audit_log (event, user_id, actor_id, ip, user_agent, details) VALUES ($1, $2, $3, $4, $5, $6)",
+		event.Event, nullableID(event.UserID), nullableID(event.ActorID), event.IP, event.UserAgent, string(details))
+	return err
+}
+
+func (r *postgresAudit) List(query AuditQuery, page Page) (*AuditPage, error) {
+	result := &AuditPage{Events: []AuditEvent{}}
+
+	fromWhere := "FROM audit_log WHERE TRUE"
+	var args []interface{}
+	for column, value := range map[string]string{"user_id": query.UserID, "actor_id": query.ActorID} {
+		if value == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(value); err != nil {
+			return result, nil
+		}
+		args = append(args, value)
+		fromWhere += " AND " + column + " = $" + strconv.Itoa(len(args))
+	}
+	if query.Event != "" {
+		args = append(args, query.Event)
+		fromWhere += " AND event = $" + strconv.Itoa(len(args))
+	}
+
+	sqlQuery, args := PaginatedQuery(auditColumns, fromWhere, args, auditSortKeys, auditSortColumns, page)
+	rows, err := r.db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rowCount := 0
+	var lastValue string
+	for rows.Next() {
+		rowCount++
+		var event AuditEvent
+		var details string
+		err := rows.Scan(&event.ID, &event.Time, &event.Event, &event.UserID, &event.ActorID, &event.IP, &event.UserAgent,
+			&details, &lastValue)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(details), &event.Details); err != nil {
+			return nil, err
+		}
+		if rowCount <= page.Limit {
+			result.Events = append(result.Events, event)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(result.Events) > 0 {
+		last := result.Events[len(result.Events)-1].ID
+		result.Next = NextCursor(auditSortKeys, page, rowCount, last, []string{last})
+	}
+	return result, nil
+}
+
+// nullableID stores an empty ID as NULL.
+func nullableID(id string) sql.NullString {
+	return sql.NullString{String: id, Valid: id != ""}
+}
+
+type postgresReadingSessions struct {
+	db *sql.DB
+}
+
+const readingSessionColumns = "id, book_id, started_at, ended_at, pages_read"
+
+func (r *postgresReadingSessions) Add(userID string, bookID int, session *ReadingSession) (*ReadingSession, error) {
+	added := *session
+	// Selecting the book makes a book of another user insert nothing.
+	err := r.db.QueryRow(`
+		INSERT INTO reading_sessions (book_id, user_id, started_at, ended_at, pages_read)
+		SELECT id, user_id, $3::timestamptz, $4::timestamptz, $5::integer FROM books WHERE id = $1 AND user_id = $2
+		RETURNING id, book_id
+		`, bookID, userID, session.StartedAt, session.EndedAt, session.PagesRead).Scan(&added.ID, &added.BookID)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &added, nil
+}
+
+func (r *postgresReadingSessions) List(userID string, bookID int, page Page) (*ReadingSessionPage, error) {
+	result := &ReadingSessionPage{Sessions: []ReadingSession{}}
+
+	sqlQuery, args := PaginatedQuery(readingSessionColumns, "FROM reading_sessions WHERE book_id = $1 AND user_id = $2",
+		[]interface{}{bookID, userID}, readingSessionSortKeys, readingSessionSortColumns, page)
+	rows, err := r.db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rowCount := 0
+	var lastValue string
+	for rows.Next() {
+		rowCount++
+		var session ReadingSession
+		err := rows.Scan(&session.ID, &session.BookID, &session.StartedAt, &session.EndedAt, &session.PagesRead, &lastValue)
+		if err != nil {
+			return nil, err
+		}
+		if rowCount <= page.Limit {
+			result.Sessions = append(result.Sessions, session)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(result.Sessions) > 0 {
+		last := result.Sessions[len(result.Sessions)-1].ID
+		result.Next = NextCursor(readingSessionSortKeys, page, rowCount, last, []string{last})
+	}
+	return result, nil
+}
+
+func (r *postgresReadingSessions) Pace(userID string) (*ReadingPace, error) {
+	var pace ReadingPace
+	var seconds float64
+	err := r.db.QueryRow(`
+		SELECT COALESCE(SUM(pages_read), 0), COALESCE(SUM(EXTRACT(EPOCH FROM ended_at - started_at)), 0)
+		FROM reading_sessions WHERE user_id = $1 AND pages_read > 0
+		`, userID).Scan(&pace.Pages, &seconds)
+	if err != nil {
+		return nil, err
+	}
+	pace.Duration = time.Duration(seconds * float64(time.Second))
+	return &pace, nil
+}
+
+// today is the value stored in date_added when a book is added or finished.
+func today() string {
+	year, month, day := time.Now().Date()
+	return fmt.Sprintf("%d-%d-%d", year, month, day)
+}
+
+// affectedOne turns an Exec result that changed no rows into ErrNotFound.
+func affectedOne(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
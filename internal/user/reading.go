@@ -0,0 +1,177 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"math"
+	"myLibrary/package/logger"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const ReadingSessionsUrl = "/sessions"
+
+var (
+	readingSessionSortKeys    = []SortKey{{Field: "id", Desc: true}}
+	readingSessionSortColumns = map[string]string{"id": "id"}
+)
+
+const (
+	// maxReadingSession bounds a single sitting; longer ones are typos.
+	maxReadingSession = 24 * time.Hour
+	// clockSkew is how far in the future a session may end, for clients
+	// whose clocks run ahead.
+	clockSkew = 5 * time.Minute
+	// minPaceHistory is how much reading has to be logged before estimates
+	// are made, so that one short session does not set the pace.
+	minPaceHistory = 15 * time.Minute
+)
+
+// AddReadingSession logs a sitting with one of the path user's books.
+func (h *handler) AddReadingSession(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	bookID, err := strconv.Atoi(params.ByName("bookID"))
+	if err != nil {
+		http.Error(w, "Bad request: Invalid book ID", http.StatusBadRequest)
+		logger.Log.Info("Bad request: Invalid book ID")
+		return
+	}
+
+	var request ReadingSessionRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Bad request body: "+err.Error(), http.StatusBadRequest)
+		logger.Log.Info("Bad request body: " + err.Error())
+		return
+	}
+
+	if request.StartedAt.IsZero() || request.EndedAt.IsZero() || request.PagesRead == nil {
+		http.Error(w, "Bad request: started_at, ended_at and pages_read are required", http.StatusBadRequest)
+		logger.Log.Info("Bad request: started_at, ended_at and pages_read are required")
+		return
+	}
+	duration := request.EndedAt.Sub(request.StartedAt)
+	if duration <= 0 || duration > maxReadingSession || request.EndedAt.After(time.Now().Add(clockSkew)) {
+		http.Error(w, "Bad request: a session has to end after it starts, within a day and not in the future", http.StatusBadRequest)
+		logger.Log.Info("Bad request: invalid reading session time")
+		return
+	}
+	if *request.PagesRead < 0 || *request.PagesRead > maxPageCount {
+		http.Error(w, "Bad request: Invalid pages_read", http.StatusBadRequest)
+		logger.Log.Info("Bad request: Invalid pages_read")
+		return
+	}
+
+	session, err := h.readings.Add(params.ByName("uuid"), bookID, &ReadingSession{
+		StartedAt: request.StartedAt,
+		EndedAt:   request.EndedAt,
+		PagesRead: *request.PagesRead,
+	})
+	if err != nil {
+		if err == ErrNotFound {
+			http.Error(w, "Bad request: Book not found", http.StatusNotFound)
+			logger.Log.Info("Bad request: Book not found")
+			return
+		}
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(session)
+	if err != nil {
+		logger.Log.Info(fmt.Sprintf("Error while sending JSON: ") + err.Error())
+		return
+	}
+}
+
+// ListReadingSessions lists the sessions of a book, newest first.
+func (h *handler) ListReadingSessions(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	bookID, err := strconv.Atoi(params.ByName("bookID"))
+	if err != nil {
+		http.Error(w, "Bad request: Invalid book ID", http.StatusBadRequest)
+		logger.Log.Info("Bad request: Invalid book ID")
+		return
+	}
+
+	page, err := ParsePage(r.URL.Query(), readingSessionSortKeys)
+	if err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		logger.Log.Info("Bad request: " + err.Error())
+		return
+	}
+
+	userID := params.ByName("uuid")
+	_, err = h.books.ByID(userID, bookID)
+	if err != nil {
+		if err == ErrNotFound {
+			http.Error(w, "Bad request: Book not found", http.StatusNotFound)
+			logger.Log.Info("Bad request: Book not found")
+			return
+		}
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+
+	sessions, err := h.readings.List(userID, bookID, page)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+
+	err = WriteList(w, r, sessions.Sessions, page, sessions.Next)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while sending JSON: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Error while sending JSON: ") + err.Error())
+		return
+	}
+}
+
+// addEstimates sets the Estimate of the books being read whose page count is
+// known, from the pace of all logged sessions of userID.
+func (h *handler) addEstimates(userID string, books ...*Book) error {
+	var pace *ReadingPace
+	for _, book := range books {
+		if book.Status != StatusReading || book.PageCount == nil {
+			continue
+		}
+		if pace == nil {
+			var err error
+			if pace, err = h.readings.Pace(userID); err != nil {
+				return err
+			}
+		}
+		book.Estimate = estimateReading(book, pace)
+	}
+	return nil
+}
+
+// estimateReading returns nil until the user has logged enough reading.
+func estimateReading(book *Book, pace *ReadingPace) *ReadingEstimate {
+	if pace.Pages == 0 || pace.Duration < minPaceHistory {
+		return nil
+	}
+
+	pagesLeft := *book.PageCount
+	switch {
+	case book.CurrentPage != nil:
+		pagesLeft -= *book.CurrentPage
+	case book.ProgressPercent != nil:
+		pagesLeft -= *book.PageCount * *book.ProgressPercent / 100
+	}
+	if pagesLeft < 0 {
+		pagesLeft = 0
+	}
+
+	pagesPerHour := float64(pace.Pages) / pace.Duration.Hours()
+	return &ReadingEstimate{
+		PagesPerHour: math.Round(pagesPerHour*10) / 10,
+		PagesLeft:    pagesLeft,
+		MinutesLeft:  int(math.Ceil(float64(pagesLeft) / pagesPerHour * 60)),
+	}
+}
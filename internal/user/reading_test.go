@@ -0,0 +1,41 @@
+package user
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEstimateReading(t *testing.T) {
+	intPtr := func(n int) *int { return &n }
+	hourly := &ReadingPace{Pages: 60, Duration: time.Hour}
+
+	for _, tc := range []struct {
+		name string
+		book Book
+		pace *ReadingPace
+		want ReadingEstimate
+	}{
+		{"by page", Book{PageCount: intPtr(300), CurrentPage: intPtr(150)}, hourly, ReadingEstimate{60, 150, 150}},
+		{"by percent", Book{PageCount: intPtr(300), ProgressPercent: intPtr(50)}, hourly, ReadingEstimate{60, 150, 150}},
+		{"not started", Book{PageCount: intPtr(300)}, hourly, ReadingEstimate{60, 300, 300}},
+		{"past the end", Book{PageCount: intPtr(300), CurrentPage: intPtr(320)}, hourly, ReadingEstimate{60, 0, 0}},
+		{"rounded", Book{PageCount: intPtr(300), CurrentPage: intPtr(290)},
+			&ReadingPace{Pages: 100, Duration: 45 * time.Minute}, ReadingEstimate{133.3, 10, 5}},
+	} {
+		estimate := estimateReading(&tc.book, tc.pace)
+		if estimate == nil || *estimate != tc.want {
+			t.Errorf("%s: got %+v, want %+v", tc.name, estimate, tc.want)
+		}
+	}
+
+	book := &Book{PageCount: intPtr(300)}
+	for _, pace := range []*ReadingPace{
+		{},
+		{Pages: 0, Duration: time.Hour},
+		{Pages: 20, Duration: 10 * time.Minute},
+	} {
+		if estimate := estimateReading(book, pace); estimate != nil {
+			t.Errorf("pace %+v: got %+v, want no estimate", pace, estimate)
+		}
+	}
+}
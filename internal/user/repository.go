@@ -0,0 +1,206 @@
+package user
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by repositories when the requested row does not
+// exist or belongs to another user.
+var ErrNotFound = errors.New("not found")
+
+// ErrAlreadyFinished is returned when a finished book is moved to finished again.
+var ErrAlreadyFinished = errors.New("book is already finished")
+
+// ErrStatusChanged is returned when a book is no longer in the status a
+// reading state was computed for.
+var ErrStatusChanged = errors.New("book status has changed")
+
+// Repositories bundles the storage the handler works with.
+type Repositories struct {
+	Users           UserRepository
+	Books           BookRepository
+	Tokens          TokenRepository
+	APIKeys         APIKeyRepository
+	Audit           AuditRepository
+	ReadingSessions ReadingSessionRepository
+}
+
+type UserRepository interface {
+	// Create stores a user whose Password is already hashed and returns it with
+	// its ID. New users always start as enabled RoleUser with an unverified email.
+	Create(user *User) (*User, error)
+	ByID(id string) (*User, error)
+	ByEmail(email string) (*User, error)
+	// IsUsernameEmailTaken reports whether another user than exceptID uses the username or email.
+	IsUsernameEmailTaken(username, email, exceptID string) (bool, error)
+	// Update overwrites username, email and password hash of user.ID; role and
+	// disabled state are kept. Changing the email marks it unverified.
+	Update(user *User) error
+	UpdatePassword(id, passwordHash string) error
+	// MarkEmailVerified verifies the email of user id if it is still email,
+	// and returns ErrNotFound otherwise.
+	MarkEmailVerified(id, email string) error
+	// Delete removes the user together with their books.
+	Delete(id string) error
+	// List returns users ordered by ID.
+	List(page Page) (*UserPage, error)
+	SetRole(id string, role Role) error
+	SetDisabled(id string, disabled bool) error
+
+	// SetTOTPSecret starts a new TOTP enrollment; 2FA stays off until EnableTOTP.
+	SetTOTPSecret(id, secret string) error
+	// EnableTOTP turns 2FA on and replaces the recovery codes with the given hashes.
+	EnableTOTP(id string, recoveryCodeHashes []string) error
+	// DisableTOTP turns 2FA off and forgets the secret and recovery codes.
+	DisableTOTP(id string) error
+	// UseTOTPStep records a successfully used time step and reports false if
+	// that step or a later one was already used.
+	UseTOTPStep(id string, step int64) (bool, error)
+	// UseRecoveryCode consumes an unused recovery code and reports whether there was one.
+	UseRecoveryCode(id, codeHash string) (bool, error)
+	// ByIdentity returns the user linked to subject at the OIDC provider issuer.
+	ByIdentity(issuer, subject string) (*User, error)
+	// LinkIdentity lets subject at issuer sign in as the user.
+	LinkIdentity(id, issuer, subject string) error
+}
+
+type UserPage struct {
+	Users []User
+	Next  *Cursor
+}
+
+// BookQuery describes one page of a book list.
+type BookQuery struct {
+	Filter BookFilter
+	Sort   []SortKey
+	Page   Page
+}
+
+type BookPage struct {
+	Books []Book
+	Next  *Cursor
+}
+
+type SearchPage struct {
+	Results []SearchResult
+	Next    *Cursor
+}
+
+// BookRepository methods taking a userID never touch books of other users.
+type BookRepository interface {
+	Add(userID string, book *Book) (*Book, error)
+	ByID(userID string, bookID int) (*Book, error)
+	// MarkFinished moves a book that is not finished yet to finished, sets its
+	// rating and comment, and resets date_added and finished_at to today. A
+	// rating of 0 leaves the book unrated.
+	MarkFinished(userID string, bookID int, rating int, comment string) (*Book, error)
+	// SetReadingState replaces the status, progress, dates, rating and comment
+	// of a book that is still in status from, and yields ErrStatusChanged
+	// otherwise.
+	SetReadingState(userID string, bookID int, from BookStatus, state *ReadingState) (*Book, error)
+	// Update applies the non-nil fields of patch and returns the updated book.
+	Update(userID string, bookID int, patch *BookPatch) (*Book, error)
+	Delete(userID string, bookID int) error
+	List(userID string, status BookStatus, query BookQuery) (*BookPage, error)
+	Search(userID, q string, query BookQuery) (*SearchPage, error)
+}
+
+// RefreshToken is a stored refresh token together with the state of its family.
+type RefreshToken struct {
+	FamilyID  string
+	UserID    string
+	ExpiresAt time.Time
+	Used      bool
+	Revoked   bool
+}
+
+// TokenRepository stores refresh tokens. Every login starts a family; each
+// refresh rotates the token within the family, and revoking the family ends
+// both its refresh tokens and the access tokens issued for it. Users see their
+// families as sessions.
+type TokenRepository interface {
+	CreateFamily(userID, userAgent, ip string) (string, error)
+	AddRefreshToken(familyID, tokenHash string, expiresAt time.Time) error
+	RefreshToken(tokenHash string) (*RefreshToken, error)
+	// UseRefreshToken marks the token as used and reports false if it already was.
+	UseRefreshToken(tokenHash string) (bool, error)
+	RevokeFamily(familyID string) error
+	RevokeUserFamilies(userID string) error
+	// RevokeOtherFamilies revokes every family of userID except keepFamilyID.
+	RevokeOtherFamilies(userID, keepFamilyID string) error
+	// IsFamilyActive reports whether familyID exists and is not revoked, and
+	// returns the user it belongs to.
+	IsFamilyActive(familyID string) (string, bool, error)
+	// TouchFamily records that the family was just used from ip.
+	TouchFamily(familyID, ip string) error
+	// Sessions returns the families of userID that are not revoked and can
+	// still be refreshed, most recently seen first.
+	Sessions(userID string) ([]Session, error)
+	// RevokeUserFamily revokes one active family of userID; any other
+	// familyID yields ErrNotFound.
+	RevokeUserFamily(userID, familyID string) error
+
+	AddPasswordReset(userID, tokenHash string, expiresAt time.Time) error
+	// UsePasswordReset consumes an unused, unexpired reset token and returns its
+	// user. Any other token yields ErrNotFound. The user's other reset tokens
+	// are consumed as well.
+	UsePasswordReset(tokenHash string) (string, error)
+}
+
+// APIKeyRepository stores API keys by the hash of the secret; the secret
+// itself is only known when the key is created.
+type APIKeyRepository interface {
+	Create(userID, keyHash string, key *APIKey) (*APIKey, error)
+	// List returns the keys of userID that are not revoked, newest first.
+	List(userID string) ([]APIKey, error)
+	// ByHash returns an active key; revoked and unknown keys yield ErrNotFound.
+	ByHash(keyHash string) (*APIKey, error)
+	Revoke(userID, id string) error
+	// RevokeUserKeys revokes every key of userID.
+	RevokeUserKeys(userID string) error
+	// Touch records that the key was just used.
+	Touch(id string) error
+}
+
+// AuditRepository is append-only: entries are never changed or removed.
+type AuditRepository interface {
+	Append(event *AuditEvent) error
+	// List returns the entries matching query, newest first.
+	List(query AuditQuery, page Page) (*AuditPage, error)
+}
+
+// AuditQuery filters the audit log; empty fields match everything.
+type AuditQuery struct {
+	UserID  string
+	ActorID string
+	Event   string
+}
+
+type AuditPage struct {
+	Events []AuditEvent
+	Next   *Cursor
+}
+
+// ReadingSessionRepository keeps the reading sessions of books. Sessions are
+// removed together with their book.
+type ReadingSessionRepository interface {
+	// Add logs a session of a book of userID, or yields ErrNotFound if the
+	// user has no such book.
+	Add(userID string, bookID int, session *ReadingSession) (*ReadingSession, error)
+	// List returns the sessions of a book, newest first.
+	List(userID string, bookID int, page Page) (*ReadingSessionPage, error)
+	// Pace sums up the sessions of userID in which pages were read.
+	Pace(userID string) (*ReadingPace, error)
+}
+
+type ReadingSessionPage struct {
+	Sessions []ReadingSession
+	Next     *Cursor
+}
+
+// ReadingPace is how many pages a user read in how much time.
+type ReadingPace struct {
+	Pages    int
+	Duration time.Duration
+}
@@ -0,0 +1,130 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"myLibrary/package/client/mailer"
+	"myLibrary/package/logger"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	ForgotPasswordUrl = "/password/forgot"
+	ResetPasswordUrl  = "/password/reset"
+)
+
+// ForgotPassword mails a one-time reset token to the address. The response is
+// the same whether or not the address belongs to a user, so it cannot be used
+// to find out who is registered.
+func (h *handler) ForgotPassword(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var request ForgotPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.Email == "" {
+		http.Error(w, "Bad request: email is required", http.StatusBadRequest)
+		logger.Log.Info("Bad request: email is required")
+		return
+	}
+
+	err = h.sendPasswordReset(request.Email)
+	if err != nil {
+		logger.Log.Error("Password reset was not sent: " + err.Error())
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *handler) sendPasswordReset(email string) error {
+	owner, err := h.users.ByEmail(email)
+	if err == ErrNotFound {
+		logger.Log.Info("Password reset requested for unknown email")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+	err = h.tokens.AddPasswordReset(owner.ID, hashToken(token), time.Now().Add(h.cfg.Key.ResetTTL))
+	if err != nil {
+		return err
+	}
+
+	// POST /password/reset takes JSON, so a link can only point at a page
+	// that sends it.
+	instructions := fmt.Sprintf("send the token below with your new password to POST %s within %d minutes",
+		h.cfg.PublicURL+ResetPasswordUrl, int(h.cfg.Key.ResetTTL.Minutes()))
+	if h.cfg.PasswordResetURL != "" {
+		instructions = fmt.Sprintf("follow the link below within %d minutes:\n\n%s",
+			int(h.cfg.Key.ResetTTL.Minutes()), h.cfg.PasswordResetURL+"?token="+url.QueryEscape(token))
+	}
+	return h.mail.Send(mailer.Message{
+		To:      owner.Email,
+		Subject: "Password reset",
+		Body: fmt.Sprintf("Hello, %s!\n\nSomeone asked to reset the password of your myLibrary account. "+
+			"If it was you, %s\n\nReset token: %s\n\nIf it was not you, ignore this message.",
+			owner.Username, instructions, token),
+	})
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword. The
+// token works once, and every session of the user is ended.
+func (h *handler) ResetPassword(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var request ResetPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Bad request body: "+err.Error(), http.StatusBadRequest)
+		logger.Log.Info("Bad request body: " + err.Error())
+		return
+	}
+
+	if request.Token == "" || request.Password == "" || !UserSuitableForRestrictions(0, len(request.Password), 0) {
+		http.Error(w, "Bad request: token and password of at most 128 characters are required", http.StatusBadRequest)
+		logger.Log.Info("Bad request: token and password of at most 128 characters are required")
+		return
+	}
+
+	passwordHash, err := HashPassword(request.Password)
+	if err != nil {
+		http.Error(w, "Error when hashing password: "+err.Error(), http.StatusInternalServerError)
+		logger.Log.Info("Error when hashing password: " + err.Error())
+		return
+	}
+
+	userID, err := h.tokens.UsePasswordReset(hashToken(request.Token))
+	if err != nil {
+		if err == ErrNotFound {
+			http.Error(w, "Bad request: reset token is invalid or expired", http.StatusBadRequest)
+			logger.Log.Info("Bad request: reset token is invalid or expired")
+			return
+		}
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+
+	err = h.users.UpdatePassword(userID, passwordHash)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+
+	err = h.tokens.RevokeUserFamilies(userID)
+	if err != nil {
+		logger.Log.Error("Password reset, but sessions were not revoked: " + err.Error())
+	}
+	err = h.apiKeys.RevokeUserKeys(userID)
+	if err != nil {
+		logger.Log.Error("Password reset, but API keys were not revoked: " + err.Error())
+	}
+	// Whoever holds the reset link acts as the user.
+	h.audit(r, AuditEvent{Event: AuditPasswordChanged, UserID: userID, ActorID: userID, Details: map[string]string{"via": "reset"}})
+
+	w.WriteHeader(http.StatusOK)
+}
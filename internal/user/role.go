@@ -0,0 +1,43 @@
+package user
+
+import "fmt"
+
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+)
+
+// Permission guards a route registered in handler.Register. Routes under
+// /user/:uuid need none: they are limited to the owner instead.
+type Permission string
+
+const (
+	PermissionListUsers     Permission = "users:list"
+	PermissionManageUsers   Permission = "users:manage"
+	PermissionDeleteContent Permission = "content:delete"
+	PermissionReadAudit     Permission = "audit:read"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleUser:  {},
+	RoleAdmin: {PermissionListUsers, PermissionManageUsers, PermissionDeleteContent, PermissionReadAudit},
+}
+
+func ParseRole(raw string) (Role, error) {
+	role := Role(raw)
+	if _, ok := rolePermissions[role]; !ok {
+		return "", fmt.Errorf("unknown role %q", raw)
+	}
+	return role, nil
+}
+
+func (r Role) Can(permission Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
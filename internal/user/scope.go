@@ -0,0 +1,70 @@
+package user
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Scope limits what an API key may do on its owner's behalf. Callers with an
+// access token from /login hold every scope.
+type Scope string
+
+const (
+	ScopeBooksRead  Scope = "books:read"
+	ScopeBooksWrite Scope = "books:write"
+	ScopeUserRead   Scope = "user:read"
+	ScopeUserWrite  Scope = "user:write"
+	// ScopeSession is never granted to API keys. Routes that manage
+	// credentials or accounts require it, so they need a real login.
+	ScopeSession Scope = "session"
+)
+
+var apiKeyScopes = []Scope{ScopeBooksRead, ScopeBooksWrite, ScopeUserRead, ScopeUserWrite}
+
+// ParseScopes validates the scopes requested for a new API key. No scopes
+// means all of them.
+func ParseScopes(raw []string) ([]Scope, error) {
+	if len(raw) == 0 {
+		return append([]Scope(nil), apiKeyScopes...), nil
+	}
+
+	scopes := make([]Scope, 0, len(raw))
+	seen := make(map[Scope]bool)
+	for _, value := range raw {
+		scope := Scope(strings.TrimSpace(value))
+		if !scope.grantable() {
+			return nil, fmt.Errorf("unknown scope %q", value)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+func (s Scope) grantable() bool {
+	for _, scope := range apiKeyScopes {
+		if scope == s {
+			return true
+		}
+	}
+	return false
+}
+
+func joinScopes(scopes []Scope) string {
+	parts := make([]string, len(scopes))
+	for i, scope := range scopes {
+		parts[i] = string(scope)
+	}
+	return strings.Join(parts, " ")
+}
+
+func splitScopes(joined string) []Scope {
+	fields := strings.Fields(joined)
+	scopes := make([]Scope, len(fields))
+	for i, field := range fields {
+		scopes[i] = Scope(field)
+	}
+	return scopes
+}
@@ -0,0 +1,67 @@
+package user
+
+import (
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"html"
+	"myLibrary/package/logger"
+	"net/http"
+	"strings"
+)
+
+// Repositories mark the matched words of a snippet with these control
+// characters rather than with tags, so that the book text can be escaped
+// before the marks become <mark> tags.
+const (
+	snippetStartSel = "\x02"
+	snippetStopSel  = "\x03"
+)
+
+var snippetMarks = strings.NewReplacer(snippetStartSel, "<mark>", snippetStopSel, "</mark>")
+
+// highlightSnippet turns a snippet with sentinel marks into HTML that is safe
+// to render: everything but the marks is escaped.
+func highlightSnippet(snippet string) string {
+	return snippetMarks.Replace(html.EscapeString(snippet))
+}
+
+// SearchBooks serves GET /user/:uuid/books/search?q=... over both finished and
+// wishlist books. q uses web search syntax ("quoted phrases", -excluded, or),
+// and the list filters can be combined with it.
+func (h *handler) SearchBooks(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		http.Error(w, "Bad request: q is required", http.StatusBadRequest)
+		logger.Log.Info("Bad request: q is required")
+		return
+	}
+
+	var query BookQuery
+	var err error
+	query.Filter, err = ParseBookFilter(r.URL.Query())
+	if err == nil {
+		query.Sort, err = ParseSort(r.URL.Query().Get("sort"), defaultSearchSort, searchSortColumns)
+	}
+	if err == nil {
+		query.Page, err = ParsePage(r.URL.Query(), query.Sort)
+	}
+	if err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		logger.Log.Info("Bad request: " + err.Error())
+		return
+	}
+
+	page, err := h.books.Search(params.ByName("uuid"), q, query)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+
+	err = WriteList(w, r, page.Results, query.Page, page.Next)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while sending JSON: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Error while sending JSON: ") + err.Error())
+		return
+	}
+}
@@ -0,0 +1,48 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestHighlightSnippet(t *testing.T) {
+	for snippet, want := range map[string]string{
+		"plain text": "plain text",
+		snippetStartSel + "Dune" + snippetStopSel + " - Herbert":   "<mark>Dune</mark> - Herbert",
+		`<img src=x onerror="alert(1)">`:                           `&lt;img src=x onerror=&#34;alert(1)&#34;&gt;`,
+		"<b>" + snippetStartSel + "bold" + snippetStopSel + "</b>": "&lt;b&gt;<mark>bold</mark>&lt;/b&gt;",
+	} {
+		if got := highlightSnippet(snippet); got != want {
+			t.Errorf("highlightSnippet(%q) = %q, want %q", snippet, got, want)
+		}
+	}
+}
+
+func TestSearchEscapesSnippets(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signUp(t, "alice", "alice@example.com")
+	tokens := s.login(t, "alice@example.com")
+
+	books := "/user/" + alice + BooksUrl
+	status, body := s.do(t, http.MethodPost, books+FinishedBooksUrl, tokens.AccessToken,
+		`{"title":"Dune","author":"Herbert","comment":"<script>alert(1)</script> sand"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("adding a book: %d %s", status, body)
+	}
+
+	status, body = s.do(t, http.MethodGet, books+SearchBooksUrl+"?q=sand", tokens.AccessToken, "")
+	if status != http.StatusOK {
+		t.Fatalf("search: %d %s", status, body)
+	}
+	var results struct {
+		Items []SearchResult `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(body), &results); err != nil {
+		t.Fatal(err)
+	}
+	want := "Dune - Herbert &lt;script&gt;alert(1)&lt;/script&gt; <mark>sand</mark>"
+	if len(results.Items) != 1 || results.Items[0].Snippet != want {
+		t.Errorf("search results: %s", body)
+	}
+}
@@ -0,0 +1,66 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"myLibrary/package/logger"
+	"net/http"
+)
+
+const (
+	SessionsUrl  = "/sessions"
+	SessionIdUrl = "/:sessionID"
+)
+
+// maxUserAgentLength bounds the user agents we store with sessions and audit events.
+const maxUserAgentLength = 512
+
+func userAgent(r *http.Request) string {
+	return truncateBytes(r.UserAgent(), maxUserAgentLength)
+}
+
+// ListSessions lists where the caller is logged in. The session of the access
+// token used for the request is marked current.
+func (h *handler) ListSessions(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	caller, _ := CallerFromContext(r.Context())
+	sessions, err := h.tokens.Sessions(caller.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == caller.FamilyID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(sessions)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while sending JSON: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Error while sending JSON: ") + err.Error())
+		return
+	}
+}
+
+// RevokeSession logs one session out. Its refresh token stops working and so
+// do its access tokens, since authenticate checks the session on every request.
+func (h *handler) RevokeSession(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	caller, _ := CallerFromContext(r.Context())
+	sessionID := params.ByName("sessionID")
+	err := h.tokens.RevokeUserFamily(caller.ID, sessionID)
+	if err != nil {
+		if err == ErrNotFound {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			logger.Log.Info("Session of user " + caller.ID + " not found")
+			return
+		}
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+	logger.Log.Info("User " + caller.ID + " revoked a session")
+	h.audit(r, AuditEvent{Event: AuditSessionRevoked, UserID: caller.ID, Details: map[string]string{"session": sessionID}})
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,88 @@
+package user
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Columns the book lists may be sorted by. Only these are ever written into
+// ORDER BY, so the sort parameter cannot inject SQL.
+var (
+	// Unrated books sort like a rating of 0, so that keyset pagination does
+	// not skip their NULLs.
+	finishedSortColumns = map[string]string{
+		"date_added": "date_added",
+		"rating":     "COALESCE(rating, 0)",
+		"title":      "lower(title)",
+		"author":     "lower(author)",
+	}
+	wishlistSortColumns = map[string]string{
+		"date_added": "date_added",
+		"title":      "lower(title)",
+		"author":     "lower(author)",
+	}
+	searchSortColumns = map[string]string{
+		"rank":       "rank",
+		"date_added": "date_added",
+		"rating":     "rating",
+		"title":      "lower(title)",
+		"author":     "lower(author)",
+	}
+)
+
+const (
+	defaultBookSort   = "date_added:desc"
+	defaultSearchSort = "rank:desc"
+)
+
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// ParseSort parses ?sort=field[:asc|desc][,field[:asc|desc]...] against the allowed fields.
+// An empty parameter falls back to fallback.
+func ParseSort(raw, fallback string, allowed map[string]string) ([]SortKey, error) {
+	if raw == "" {
+		raw = fallback
+	}
+
+	var keys []SortKey
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(raw, ",") {
+		field, direction, _ := strings.Cut(strings.TrimSpace(part), ":")
+		if _, ok := allowed[field]; !ok {
+			return nil, fmt.Errorf("unknown sort field %q", field)
+		}
+		if seen[field] {
+			return nil, fmt.Errorf("duplicate sort field %q", field)
+		}
+		seen[field] = true
+
+		key := SortKey{Field: field}
+		switch strings.ToLower(direction) {
+		case "", "asc":
+		case "desc":
+			key.Desc = true
+		default:
+			return nil, fmt.Errorf("unknown sort direction %q", direction)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// OrderBy builds an ORDER BY clause from parsed keys. The book id is always
+// the last key so that books with equal values keep a stable order.
+func OrderBy(keys []SortKey, columns map[string]string) string {
+	terms := make([]string, 0, len(keys)+1)
+	for _, key := range keys {
+		direction := "ASC"
+		if key.Desc {
+			direction = "DESC"
+		}
+		terms = append(terms, columns[key.Field]+" "+direction)
+	}
+	terms = append(terms, "id ASC")
+	return "ORDER BY " + strings.Join(terms, ", ")
+}
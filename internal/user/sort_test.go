@@ -0,0 +1,34 @@
+package user
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSort(t *testing.T) {
+	for raw, want := range map[string][]SortKey{
+		"":                           {{Field: "date_added", Desc: true}},
+		"title":                      {{Field: "title"}},
+		"rating:DESC, title":         {{Field: "rating", Desc: true}, {Field: "title"}},
+		"author:asc,date_added:desc": {{Field: "author"}, {Field: "date_added", Desc: true}},
+	} {
+		keys, err := ParseSort(raw, defaultBookSort, finishedSortColumns)
+		if err != nil || !reflect.DeepEqual(keys, want) {
+			t.Errorf("ParseSort(%q) = %+v, %v, want %+v", raw, keys, err, want)
+		}
+	}
+
+	for _, raw := range []string{"rank", "title;DROP TABLE books", "title:up", "title,title:desc", "title,"} {
+		if keys, err := ParseSort(raw, defaultBookSort, finishedSortColumns); err == nil {
+			t.Errorf("ParseSort(%q) = %+v, want an error", raw, keys)
+		}
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	keys := []SortKey{{Field: "rating", Desc: true}, {Field: "title"}}
+	want := "ORDER BY COALESCE(rating, 0) DESC, lower(title) ASC, id ASC"
+	if got := OrderBy(keys, finishedSortColumns); got != want {
+		t.Errorf("OrderBy = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,283 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"myLibrary/package/logger"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	ReadingBooksUrl   = "/reading"
+	AbandonedBooksUrl = "/abandoned"
+	StartUrl          = "/start"
+	FinishUrl         = "/finish"
+	AbandonUrl        = "/abandon"
+	ProgressUrl       = "/progress"
+)
+
+// BookStatus is where a book is on the user's shelf. The wishlist holds the
+// want_to_read books.
+type BookStatus string
+
+const (
+	StatusWantToRead BookStatus = "want_to_read"
+	StatusReading    BookStatus = "reading"
+	StatusFinished   BookStatus = "finished"
+	StatusAbandoned  BookStatus = "abandoned"
+)
+
+// bookTransitions lists the statuses each status can be reached from.
+// Finished books stay finished.
+var bookTransitions = map[BookStatus][]BookStatus{
+	StatusWantToRead: {},
+	StatusReading:    {StatusWantToRead, StatusAbandoned},
+	StatusFinished:   {StatusWantToRead, StatusReading, StatusAbandoned},
+	StatusAbandoned:  {StatusReading},
+}
+
+// maxPageCount keeps page numbers within reason.
+const maxPageCount = 100000
+
+func ParseBookStatus(raw string) (BookStatus, error) {
+	status := BookStatus(raw)
+	if _, ok := bookTransitions[status]; !ok {
+		return "", fmt.Errorf("unknown status %q", raw)
+	}
+	return status, nil
+}
+
+func (s BookStatus) canBecome(to BookStatus) bool {
+	for _, from := range bookTransitions[to] {
+		if from == s {
+			return true
+		}
+	}
+	return false
+}
+
+// readingStateOf is the current reading state of book, to be changed and
+// written back with SetReadingState.
+func readingStateOf(book *Book) ReadingState {
+	state := ReadingState{
+		Status:      book.Status,
+		PageCount:   book.PageCount,
+		CurrentPage: book.CurrentPage,
+		StartedAt:   book.StartedAt,
+		FinishedAt:  book.FinishedAt,
+		Comment:     book.Comment,
+	}
+	if book.Rating != 0 {
+		rating := book.Rating
+		state.Rating = &rating
+	}
+	// With a current page the percentage is derived, not stored.
+	if book.CurrentPage == nil {
+		state.ProgressPercent = book.ProgressPercent
+	}
+	return state
+}
+
+// StartBook moves a wishlist or abandoned book to reading. A resumed book
+// keeps its start date and progress unless a date is given.
+func (h *handler) StartBook(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	h.changeBookStatus(w, r, params, StatusReading)
+}
+
+// FinishBook moves a book to finished, optionally with a rating and comment.
+func (h *handler) FinishBook(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	h.changeBookStatus(w, r, params, StatusFinished)
+}
+
+// AbandonBook stops reading a book. Its progress is kept.
+func (h *handler) AbandonBook(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	h.changeBookStatus(w, r, params, StatusAbandoned)
+}
+
+func (h *handler) changeBookStatus(w http.ResponseWriter, r *http.Request, params httprouter.Params, to BookStatus) {
+	bookID, err := strconv.Atoi(params.ByName("bookID"))
+	if err != nil {
+		http.Error(w, "Bad request: Invalid book ID", http.StatusBadRequest)
+		logger.Log.Info("Bad request: Invalid book ID")
+		return
+	}
+
+	// The body is optional.
+	var request StatusChangeRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil && err != io.EOF {
+		http.Error(w, "Bad request body: "+err.Error(), http.StatusBadRequest)
+		logger.Log.Info("Bad request body: " + err.Error())
+		return
+	}
+
+	date := time.Now().Format(filterDateLayout)
+	if request.Date != "" {
+		if _, err := time.Parse(filterDateLayout, request.Date); err != nil {
+			http.Error(w, "Bad request: date must be in YYYY-MM-DD format", http.StatusBadRequest)
+			logger.Log.Info("Bad request: date must be in YYYY-MM-DD format")
+			return
+		}
+		date = request.Date
+	}
+	if request.Rating != nil && (to != StatusFinished || *request.Rating < 1 || *request.Rating > 10) {
+		http.Error(w, "Bad request: Only finished books can be rated, from 1 to 10", http.StatusBadRequest)
+		logger.Log.Info("Bad request: Only finished books can be rated, from 1 to 10")
+		return
+	}
+
+	userID := params.ByName("uuid")
+	book, err := h.books.ByID(userID, bookID)
+	if err != nil {
+		if err == ErrNotFound {
+			http.Error(w, "Bad request: Book not found", http.StatusNotFound)
+			logger.Log.Info("Bad request: Book not found")
+			return
+		}
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+
+	if book.Status == StatusFinished && to == StatusFinished {
+		http.Error(w, "Bad request: Book is already finished", http.StatusConflict)
+		logger.Log.Info("Bad request: Book is already finished")
+		return
+	}
+	if !book.Status.canBecome(to) {
+		http.Error(w, fmt.Sprintf("Bad request: a %s book cannot become %s", book.Status, to), http.StatusConflict)
+		logger.Log.Info(fmt.Sprintf("Bad request: a %s book cannot become %s", book.Status, to))
+		return
+	}
+
+	state := readingStateOf(book)
+	state.Status = to
+	switch to {
+	case StatusReading:
+		if state.StartedAt == "" || request.Date != "" {
+			state.StartedAt = date
+		}
+	case StatusFinished:
+		if state.StartedAt != "" && date < state.StartedAt {
+			http.Error(w, "Bad request: a book cannot be finished before it was started", http.StatusBadRequest)
+			logger.Log.Info("Bad request: a book cannot be finished before it was started")
+			return
+		}
+		state.FinishedAt = date
+		if state.PageCount != nil {
+			state.CurrentPage, state.ProgressPercent = state.PageCount, nil
+		} else {
+			complete := 100
+			state.CurrentPage, state.ProgressPercent = nil, &complete
+		}
+		if request.Rating != nil {
+			state.Rating = request.Rating
+		}
+	}
+	if request.Comment != nil {
+		state.Comment = *request.Comment
+	}
+
+	h.writeReadingState(w, userID, bookID, book.Status, &state)
+}
+
+// UpdateProgress records how far into a book being read the user is.
+func (h *handler) UpdateProgress(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	bookID, err := strconv.Atoi(params.ByName("bookID"))
+	if err != nil {
+		http.Error(w, "Bad request: Invalid book ID", http.StatusBadRequest)
+		logger.Log.Info("Bad request: Invalid book ID")
+		return
+	}
+
+	var request ProgressRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Bad request body: "+err.Error(), http.StatusBadRequest)
+		logger.Log.Info("Bad request body: " + err.Error())
+		return
+	}
+
+	if (request.Page == nil) == (request.Percent == nil) || (request.PageCount != nil && request.Page == nil) {
+		http.Error(w, "Bad request: give either page (and optionally page_count) or percent", http.StatusBadRequest)
+		logger.Log.Info("Bad request: give either page (and optionally page_count) or percent")
+		return
+	}
+	if (request.Percent != nil && (*request.Percent < 0 || *request.Percent > 100)) ||
+		(request.Page != nil && (*request.Page < 0 || *request.Page > maxPageCount)) ||
+		(request.PageCount != nil && (*request.PageCount < 1 || *request.PageCount > maxPageCount)) {
+		http.Error(w, "Bad request: Invalid page/page_count/percent", http.StatusBadRequest)
+		logger.Log.Info("Bad request: Invalid page/page_count/percent")
+		return
+	}
+
+	userID := params.ByName("uuid")
+	book, err := h.books.ByID(userID, bookID)
+	if err != nil {
+		if err == ErrNotFound {
+			http.Error(w, "Bad request: Book not found", http.StatusNotFound)
+			logger.Log.Info("Bad request: Book not found")
+			return
+		}
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+
+	if book.Status != StatusReading {
+		http.Error(w, "Bad request: progress is only recorded for books being read", http.StatusConflict)
+		logger.Log.Info("Bad request: progress is only recorded for books being read")
+		return
+	}
+
+	state := readingStateOf(book)
+	if request.Percent != nil {
+		state.CurrentPage, state.ProgressPercent = nil, request.Percent
+	} else {
+		if request.PageCount != nil {
+			state.PageCount = request.PageCount
+		}
+		if state.PageCount != nil && *request.Page > *state.PageCount {
+			http.Error(w, "Bad request: page is beyond page_count", http.StatusBadRequest)
+			logger.Log.Info("Bad request: page is beyond page_count")
+			return
+		}
+		state.CurrentPage, state.ProgressPercent = request.Page, nil
+	}
+
+	h.writeReadingState(w, userID, bookID, book.Status, &state)
+}
+
+// writeReadingState stores state and responds with the updated book.
+func (h *handler) writeReadingState(w http.ResponseWriter, userID string, bookID int, from BookStatus, state *ReadingState) {
+	book, err := h.books.SetReadingState(userID, bookID, from, state)
+	if err != nil {
+		switch err {
+		case ErrNotFound:
+			http.Error(w, "Bad request: Book not found", http.StatusNotFound)
+			logger.Log.Info("Bad request: Book not found")
+		case ErrStatusChanged:
+			http.Error(w, "Conflict: the book was changed by another request, try again", http.StatusConflict)
+			logger.Log.Info("Conflict: concurrent status change of book " + strconv.Itoa(bookID))
+		default:
+			http.Error(w, "Error updating book: "+err.Error(), http.StatusInternalServerError)
+			logger.Log.Info("Error updating book: " + err.Error())
+		}
+		return
+	}
+	if err := h.addEstimates(userID, book); err != nil {
+		logger.Log.Error("Book updated, but its estimate was not computed: " + err.Error())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(book)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while sending JSON: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Error while sending JSON: ") + err.Error())
+		return
+	}
+}
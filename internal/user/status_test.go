@@ -0,0 +1,99 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestParseBookStatus(t *testing.T) {
+	for _, raw := range []string{"want_to_read", "reading", "finished", "abandoned"} {
+		if status, err := ParseBookStatus(raw); err != nil || string(status) != raw {
+			t.Errorf("ParseBookStatus(%q) = %q, %v", raw, status, err)
+		}
+	}
+	for _, raw := range []string{"", "Reading", "read", "wishlist"} {
+		if status, err := ParseBookStatus(raw); err == nil {
+			t.Errorf("ParseBookStatus(%q) = %q, want an error", raw, status)
+		}
+	}
+}
+
+func TestBookTransitions(t *testing.T) {
+	statuses := []BookStatus{StatusWantToRead, StatusReading, StatusFinished, StatusAbandoned}
+	allowed := map[[2]BookStatus]bool{
+		{StatusWantToRead, StatusReading}:  true,
+		{StatusWantToRead, StatusFinished}: true,
+		{StatusReading, StatusFinished}:    true,
+		{StatusReading, StatusAbandoned}:   true,
+		{StatusAbandoned, StatusReading}:   true,
+		{StatusAbandoned, StatusFinished}:  true,
+	}
+	for _, from := range statuses {
+		for _, to := range statuses {
+			if got := from.canBecome(to); got != allowed[[2]BookStatus{from, to}] {
+				t.Errorf("%s can become %s: %v", from, to, got)
+			}
+		}
+	}
+}
+
+func TestUpdateProgress(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signUp(t, "alice", "alice@example.com")
+	tokens := s.login(t, "alice@example.com")
+
+	books := "/user/" + alice + BooksUrl
+	status, body := s.do(t, http.MethodPost, books+WishlistBooksUrl, tokens.AccessToken, `{"title":"Dune","author":"Herbert"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("adding a book: %d %s", status, body)
+	}
+	status, body = s.do(t, http.MethodGet, books+WishlistBooksUrl, tokens.AccessToken, "")
+	var wishlist struct {
+		Items []Book `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(body), &wishlist); err != nil || status != http.StatusOK || len(wishlist.Items) != 1 {
+		t.Fatalf("wishlist: %d %s", status, body)
+	}
+	book := wishlist.Items[0]
+	path := books + "/" + book.ID
+
+	if status, body := s.do(t, http.MethodPost, path+ProgressUrl, tokens.AccessToken, `{"page":10}`); status != http.StatusConflict {
+		t.Errorf("progress of a wishlist book: %d %s", status, body)
+	}
+	if status, body := s.do(t, http.MethodPost, path+StartUrl, tokens.AccessToken, ""); status != http.StatusOK {
+		t.Fatalf("starting the book: %d %s", status, body)
+	}
+
+	for _, tc := range []struct {
+		body string
+		want int
+	}{
+		{`{}`, http.StatusBadRequest},
+		{`{"page":10,"percent":5}`, http.StatusBadRequest},
+		{`{"page_count":300}`, http.StatusBadRequest},
+		{`{"percent":-1}`, http.StatusBadRequest},
+		{`{"percent":101}`, http.StatusBadRequest},
+		{`{"page":-1}`, http.StatusBadRequest},
+		{`{"page":10,"page_count":0}`, http.StatusBadRequest},
+		{`{"page":10,"page_count":100001}`, http.StatusBadRequest},
+		{`{"page":301,"page_count":300}`, http.StatusBadRequest},
+		{`{"page":150,"page_count":300}`, http.StatusOK},
+		{`{"page":301}`, http.StatusBadRequest},
+		{`{"page":300}`, http.StatusOK},
+		{`{"percent":0}`, http.StatusOK},
+		{`{"percent":100}`, http.StatusOK},
+	} {
+		if status, body := s.do(t, http.MethodPost, path+ProgressUrl, tokens.AccessToken, tc.body); status != tc.want {
+			t.Errorf("progress %s: got %d %s, want %d", tc.body, status, body, tc.want)
+		}
+	}
+
+	status, body = s.do(t, http.MethodPost, path+ProgressUrl, tokens.AccessToken, `{"page":75}`)
+	if err := json.Unmarshal([]byte(body), &book); err != nil || status != http.StatusOK {
+		t.Fatalf("progress: %d %s", status, body)
+	}
+	if book.ProgressPercent == nil || *book.ProgressPercent != 25 {
+		t.Errorf("75 of 300 pages: %s", body)
+	}
+}
@@ -1,14 +1,124 @@
 package user
 
+import "time"
+
 type User struct {
-	ID       string `json:"user_id"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Email    string `json:"email"`
+	ID            string `json:"user_id"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Role          Role   `json:"role"`
+	Disabled      bool   `json:"disabled"`
+	TOTPEnabled   bool   `json:"totp_enabled"`
+	// TOTPSecret is set once enrollment starts and only used while it is stored.
+	TOTPSecret string `json:"-"`
+}
+
+// UserSummary is a user as GET /admin/users lists it, without the password hash.
+type UserSummary struct {
+	ID            string `json:"user_id"`
+	Username      string `json:"username"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Role          Role   `json:"role"`
+	Disabled      bool   `json:"disabled"`
+	TOTPEnabled   bool   `json:"totp_enabled"`
+}
+
+// UpdateUserRequest is the body of PUT and PATCH /user/:uuid.
+type UpdateUserRequest struct {
+	User
+	// CurrentPassword confirms a change of the email or password.
+	CurrentPassword string `json:"current_password"`
 }
 
 type LoginResponse struct {
-	AccessToken string `json:"access_token"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// MFAChallengeResponse is the answer to a correct password when the account
+// has two-factor authentication: the tokens are issued by POST /login/2fa.
+type MFAChallengeResponse struct {
+	MFARequired    bool   `json:"mfa_required"`
+	ChallengeToken string `json:"challenge_token"`
+	ExpiresIn      int    `json:"expires_in"`
+}
+
+type MFALoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+type TOTPEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+type TOTPCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type APIKey struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []Scope    `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// CreatedAPIKey is the only response that contains the key itself.
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// Session is a login of the user, that is a refresh token family.
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// Current marks the session of the access token the list was requested with.
+	Current bool `json:"current"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
 }
 
 type LoginRequest struct {
@@ -33,3 +143,108 @@ type FinishedBook struct {
 	Rating        int    `json:"rating"`
 	Comment       string `json:"comment"`
 }
+
+type Book struct {
+	ID            string     `json:"id"`
+	Title         string     `json:"title"`
+	Author        string     `json:"author"`
+	CoverImage    string     `json:"cover_image"`
+	DateWhenAdded string     `json:"date_added"`
+	Rating        int        `json:"rating,omitempty"`
+	Comment       string     `json:"comment,omitempty"`
+	Status        BookStatus `json:"status"`
+	PageCount     *int       `json:"page_count,omitempty"`
+	// CurrentPage is set when progress is tracked by page. ProgressPercent is
+	// then derived from it and PageCount.
+	CurrentPage     *int `json:"current_page,omitempty"`
+	ProgressPercent *int `json:"progress_percent,omitempty"`
+	// StartedAt and FinishedAt are dates in YYYY-MM-DD format, empty when unknown.
+	StartedAt  string `json:"started_at,omitempty"`
+	FinishedAt string `json:"finished_at,omitempty"`
+	// Estimate is computed for books being read, never stored.
+	Estimate *ReadingEstimate `json:"estimate,omitempty"`
+}
+
+// BookPatch holds the fields of a PATCH request; nil fields are left unchanged.
+type BookPatch struct {
+	Title      *string `json:"title"`
+	Author     *string `json:"author"`
+	CoverImage *string `json:"cover_image"`
+	Rating     *int    `json:"rating"`
+	Comment    *string `json:"comment"`
+	PageCount  *int    `json:"page_count"`
+}
+
+// ReadingState is everything a status change or progress update writes.
+// Progress is stored as either CurrentPage or ProgressPercent. Rating is nil
+// for a book that is not rated.
+type ReadingState struct {
+	Status          BookStatus
+	PageCount       *int
+	CurrentPage     *int
+	ProgressPercent *int
+	StartedAt       string
+	FinishedAt      string
+	Rating          *int
+	Comment         string
+}
+
+// StatusChangeRequest is the optional body of the status transitions. Date
+// (YYYY-MM-DD) backdates the start or finish, rating and comment apply when
+// finishing.
+type StatusChangeRequest struct {
+	Date    string  `json:"date"`
+	Rating  *int    `json:"rating"`
+	Comment *string `json:"comment"`
+}
+
+// ProgressRequest sets the progress of a book being read, either as a page
+// (page_count may be given along) or as a percentage.
+type ProgressRequest struct {
+	Page      *int `json:"page"`
+	PageCount *int `json:"page_count"`
+	Percent   *int `json:"percent"`
+}
+
+// ReadingSession is one sitting with a book.
+type ReadingSession struct {
+	ID        string    `json:"id"`
+	BookID    string    `json:"book_id"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+	PagesRead int       `json:"pages_read"`
+}
+
+// ReadingSessionRequest is the body of POST .../:bookID/sessions.
+type ReadingSessionRequest struct {
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+	PagesRead *int      `json:"pages_read"`
+}
+
+// ReadingEstimate is how long the rest of a book takes at the user's pace.
+type ReadingEstimate struct {
+	PagesPerHour float64 `json:"pages_per_hour"`
+	PagesLeft    int     `json:"pages_left"`
+	MinutesLeft  int     `json:"minutes_left"`
+}
+
+type SearchResult struct {
+	Book
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// AuditEvent is one entry of the audit log. UserID is the account the event
+// is about, ActorID the user who caused it; both are empty when unknown, for
+// example for a login attempt with an unregistered email.
+type AuditEvent struct {
+	ID        string            `json:"id"`
+	Time      time.Time         `json:"time"`
+	Event     string            `json:"event"`
+	UserID    string            `json:"user_id,omitempty"`
+	ActorID   string            `json:"actor_id,omitempty"`
+	IP        string            `json:"ip"`
+	UserAgent string            `json:"user_agent"`
+	Details   map[string]string `json:"details,omitempty"`
+}
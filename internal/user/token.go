@@ -0,0 +1,295 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/julienschmidt/httprouter"
+	"myLibrary/internal/config"
+	"myLibrary/package/logger"
+	"net/http"
+	"time"
+)
+
+const (
+	RefreshTokenUrl = "/token/refresh"
+	LogoutUrl       = "/logout"
+	LogoutAllUrl    = "/logout/all"
+	JWKSUrl         = "/.well-known/jwks.json"
+)
+
+// randomToken returns 32 random bytes encoded for use in URLs and JSON.
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken is what the database stores instead of the refresh token itself.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokens signs an access token for user and stores a new refresh token in familyID.
+func (h *handler) issueTokens(user *User, familyID string) (*LoginResponse, error) {
+	payload := jwt.MapClaims{
+		"sub":  user.ID,
+		"fam":  familyID,
+		"role": user.Role,
+		"exp":  time.Now().Add(h.cfg.Key.AccessTTL).Unix(),
+	}
+	accessToken, err := h.signer.Sign(payload)
+	if err != nil {
+		return nil, fmt.Errorf("signing access token: %w", err)
+	}
+
+	refreshToken, err := randomToken()
+	if err != nil {
+		return nil, fmt.Errorf("generating refresh token: %w", err)
+	}
+	err = h.tokens.AddRefreshToken(familyID, hashToken(refreshToken), time.Now().Add(h.cfg.Key.RefreshTTL))
+	if err != nil {
+		return nil, fmt.Errorf("storing refresh token: %w", err)
+	}
+
+	return &LoginResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(h.cfg.Key.AccessTTL.Seconds()),
+	}, nil
+}
+
+// Purposes of the short-lived tokens signed like access tokens. The
+// "pur" claim keeps them from being accepted in place of each other or of an
+// access token, which never has one.
+const (
+	verifyEmailPurpose  = "verify_email"
+	mfaChallengePurpose = "mfa_challenge"
+)
+
+// signPurposeToken signs a token for subject that parsePurposeToken only accepts for purpose.
+func (h *handler) signPurposeToken(purpose, subject string, ttl time.Duration, extra jwt.MapClaims) (string, error) {
+	payload := jwt.MapClaims{
+		"sub": subject,
+		"pur": purpose,
+		"exp": time.Now().Add(ttl).Unix(),
+	}
+	for key, value := range extra {
+		payload[key] = value
+	}
+	return h.signer.Sign(payload)
+}
+
+func (h *handler) parsePurposeToken(tokenString, purpose string) (jwt.MapClaims, error) {
+	claims, err := h.signer.Parse(tokenString)
+	if err != nil || claims["pur"] != purpose {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, fmt.Errorf("token has no expiry")
+	}
+	if subject, ok := claims["sub"].(string); !ok || subject == "" {
+		return nil, fmt.Errorf("token has no subject")
+	}
+	return claims, nil
+}
+
+// parseAccessToken verifies the signature and expiry of an access token and
+// returns the ID of its user and the refresh token family it was issued for.
+// The ID rather than the email is the subject, since emails can change and be
+// registered again by someone else.
+func (h *handler) parseAccessToken(tokenString string) (string, string, error) {
+	claims, err := h.signer.Parse(tokenString)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid token")
+	}
+	if _, ok := claims["pur"]; ok {
+		return "", "", fmt.Errorf("invalid token")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return "", "", fmt.Errorf("token has no expiry")
+	}
+	subject, ok := claims["sub"].(string)
+	if !ok || subject == "" {
+		return "", "", fmt.Errorf("token has no subject")
+	}
+	familyID, ok := claims["fam"].(string)
+	if !ok || familyID == "" {
+		return "", "", fmt.Errorf("token has no session")
+	}
+	return subject, familyID, nil
+}
+
+// longestTokenTTL is how long a JWT we sign may stay valid, and so how long a
+// replaced signing key has to keep verifying.
+func longestTokenTTL(cfg config.JWTSecretKey) time.Duration {
+	longest := mfaChallengeTTL
+	for _, ttl := range []time.Duration{cfg.AccessTTL, cfg.VerifyTTL, oidcLoginTTL} {
+		if ttl > longest {
+			longest = ttl
+		}
+	}
+	return longest
+}
+
+// JWKS publishes the public signing keys so other services can verify our
+// access tokens without sharing a secret.
+func (h *handler) JWKS(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	err := json.NewEncoder(w).Encode(h.signer.JWKS())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while sending JSON: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Error while sending JSON: ") + err.Error())
+		return
+	}
+}
+
+// RefreshTokens exchanges a refresh token for a new access/refresh pair. Every
+// refresh token works once: presenting a used one means it was copied, so the
+// whole family is revoked and its owner has to log in again.
+func (h *handler) RefreshTokens(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var request RefreshRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.RefreshToken == "" {
+		http.Error(w, "Bad request: refresh_token is required", http.StatusBadRequest)
+		logger.Log.Info("Bad request: refresh_token is required")
+		return
+	}
+
+	tokenHash := hashToken(request.RefreshToken)
+	stored, err := h.tokens.RefreshToken(tokenHash)
+	if err != nil {
+		if err == ErrNotFound {
+			unauthorized(w, "Unauthorized: invalid refresh token")
+			return
+		}
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+
+	if stored.Revoked {
+		unauthorized(w, "Unauthorized: refresh token revoked")
+		return
+	}
+	if stored.Used {
+		h.revokeReusedFamily(r, stored)
+		unauthorized(w, "Unauthorized: refresh token reused")
+		return
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		unauthorized(w, "Unauthorized: refresh token expired")
+		return
+	}
+
+	first, err := h.tokens.UseRefreshToken(tokenHash)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+	if !first {
+		// A concurrent request rotated the same token between our read and write.
+		h.revokeReusedFamily(r, stored)
+		unauthorized(w, "Unauthorized: refresh token reused")
+		return
+	}
+
+	owner, err := h.users.ByID(stored.UserID)
+	if err != nil {
+		if err == ErrNotFound {
+			unauthorized(w, "Unauthorized: token owner no longer exists")
+			return
+		}
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+
+	if owner.Disabled {
+		unauthorized(w, "Unauthorized: account is disabled")
+		return
+	}
+
+	response, err := h.issueTokens(owner, stored.FamilyID)
+	if err != nil {
+		http.Error(w, "Error when issuing tokens: "+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info("Error when issuing tokens: " + err.Error())
+		return
+	}
+	if err := h.tokens.TouchFamily(stored.FamilyID, h.proxies.ClientIP(r)); err != nil {
+		logger.Log.Info("Could not record use of session: " + err.Error())
+	}
+	h.audit(r, AuditEvent{
+		Event:   AuditTokenRefreshed,
+		UserID:  owner.ID,
+		ActorID: owner.ID,
+		Details: map[string]string{"session": stored.FamilyID},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while sending JSON: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Error while sending JSON: ") + err.Error())
+		return
+	}
+}
+
+func (h *handler) revokeReusedFamily(r *http.Request, stored *RefreshToken) {
+	logger.Log.Warn("Refresh token reuse detected, revoking session of user " + stored.UserID)
+	err := h.tokens.RevokeFamily(stored.FamilyID)
+	if err != nil {
+		logger.Log.Error("Error when revoking token family: " + err.Error())
+	}
+	h.audit(r, AuditEvent{Event: AuditTokenReused, UserID: stored.UserID, Details: map[string]string{"session": stored.FamilyID}})
+}
+
+// Logout revokes the session the refresh token belongs to. Unknown tokens are
+// accepted so the call can be repeated safely.
+func (h *handler) Logout(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var request RefreshRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.RefreshToken == "" {
+		http.Error(w, "Bad request: refresh_token is required", http.StatusBadRequest)
+		logger.Log.Info("Bad request: refresh_token is required")
+		return
+	}
+
+	stored, err := h.tokens.RefreshToken(hashToken(request.RefreshToken))
+	if err == nil {
+		err = h.tokens.RevokeFamily(stored.FamilyID)
+	}
+	if err != nil && err != ErrNotFound {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// LogoutEverywhere revokes every session of the caller, including the current one.
+func (h *handler) LogoutEverywhere(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	caller, _ := CallerFromContext(r.Context())
+
+	err := h.tokens.RevokeUserFamilies(caller.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+	h.audit(r, AuditEvent{Event: AuditLogoutAll, UserID: caller.ID})
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,280 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/julienschmidt/httprouter"
+	"myLibrary/package/logger"
+	"net/http"
+	"time"
+)
+
+const (
+	TwoFactorUrl = "/2fa"
+	EnrollUrl    = "/enroll"
+	ConfirmUrl   = "/confirm"
+)
+
+// mfaChallengeTTL is how long the user has to type the code after the password.
+const mfaChallengeTTL = 5 * time.Minute
+
+// challengeSecondFactor answers a correct password of a 2FA account with a
+// challenge token instead of a session. The token remembers the first factor
+// method for the audit log.
+func (h *handler) challengeSecondFactor(w http.ResponseWriter, user *User, method string) {
+	challenge, err := h.signPurposeToken(mfaChallengePurpose, user.ID, mfaChallengeTTL, jwt.MapClaims{"method": method})
+	if err != nil {
+		http.Error(w, "Error when issuing tokens: "+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info("Error when issuing tokens: " + err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(MFAChallengeResponse{
+		MFARequired:    true,
+		ChallengeToken: challenge,
+		ExpiresIn:      int(mfaChallengeTTL.Seconds()),
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while sending JSON: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Error while sending JSON: ") + err.Error())
+		return
+	}
+}
+
+// LoginSecondFactor finishes a login started by LoginUser with a TOTP code or
+// a recovery code.
+func (h *handler) LoginSecondFactor(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var request MFALoginRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Bad request body: "+err.Error(), http.StatusBadRequest)
+		logger.Log.Info("Bad request body: " + err.Error())
+		return
+	}
+
+	claims, err := h.parsePurposeToken(request.ChallengeToken, mfaChallengePurpose)
+	if err != nil {
+		unauthorized(w, "Unauthorized: invalid or expired challenge")
+		return
+	}
+
+	owner, err := h.users.ByID(claims["sub"].(string))
+	if err != nil {
+		if err == ErrNotFound {
+			unauthorized(w, "Unauthorized: invalid or expired challenge")
+			return
+		}
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+	if owner.Disabled || !owner.TOTPEnabled {
+		unauthorized(w, "Unauthorized: invalid or expired challenge")
+		return
+	}
+
+	now := time.Now()
+	accountKey, ipKey := accountLimitKey(owner.Email), h.ipLimitKey(r)
+	if wait := h.limiter.retryAfter(now, accountKey, ipKey); wait > 0 {
+		tooManyAttempts(w, wait)
+		logger.Log.Info("Second factor throttled for " + ipKey)
+		return
+	}
+
+	ok, err := h.checkSecondFactor(owner, request.Code, request.RecoveryCode)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+	if !ok {
+		h.limiter.failAccount(now, accountKey)
+		h.limiter.failIP(now, ipKey)
+		unauthorized(w, "Unauthorized: invalid code")
+		h.auditFailedLogin(r, owner, owner.Email, "second_factor")
+		return
+	}
+	h.limiter.reset(accountKey)
+
+	method, _ := claims["method"].(string)
+	if request.RecoveryCode != "" {
+		method += "+recovery_code"
+	} else {
+		method += "+totp"
+	}
+	h.startSession(w, r, owner, method)
+}
+
+// checkSecondFactor accepts either a TOTP code that was not used before or an
+// unused recovery code, and consumes it.
+func (h *handler) checkSecondFactor(user *User, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		used, err := h.users.UseRecoveryCode(user.ID, hashRecoveryCode(recoveryCode))
+		if used {
+			logger.Log.Info("Recovery code used by user " + user.ID)
+		}
+		return used, err
+	}
+
+	step, ok := verifyTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return h.users.UseTOTPStep(user.ID, step)
+}
+
+// EnrollTOTP starts 2FA enrollment. It stays off until ConfirmTOTP proves the
+// authenticator app produces the same codes.
+func (h *handler) EnrollTOTP(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	caller, _ := CallerFromContext(r.Context())
+	owner, err := h.users.ByID(caller.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+	if owner.TOTPEnabled {
+		http.Error(w, "Bad request: two-factor authentication is already enabled", http.StatusConflict)
+		logger.Log.Info("Bad request: two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		http.Error(w, "Error when generating secret: "+err.Error(), http.StatusInternalServerError)
+		logger.Log.Info("Error when generating secret: " + err.Error())
+		return
+	}
+
+	err = h.users.SetTOTPSecret(owner.ID, secret)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(TOTPEnrollResponse{Secret: secret, OtpauthURI: totpURI(secret, owner.Email)})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while sending JSON: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Error while sending JSON: ") + err.Error())
+		return
+	}
+}
+
+// ConfirmTOTP enables 2FA with the first code from the app and responds with
+// the recovery codes. They are shown only this once.
+func (h *handler) ConfirmTOTP(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var request TOTPCodeRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Bad request body: "+err.Error(), http.StatusBadRequest)
+		logger.Log.Info("Bad request body: " + err.Error())
+		return
+	}
+
+	caller, _ := CallerFromContext(r.Context())
+	owner, err := h.users.ByID(caller.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+	if owner.TOTPEnabled {
+		http.Error(w, "Bad request: two-factor authentication is already enabled", http.StatusConflict)
+		logger.Log.Info("Bad request: two-factor authentication is already enabled")
+		return
+	}
+	if owner.TOTPSecret == "" {
+		http.Error(w, "Bad request: enrollment was not started", http.StatusBadRequest)
+		logger.Log.Info("Bad request: enrollment was not started")
+		return
+	}
+
+	step, ok := verifyTOTP(owner.TOTPSecret, request.Code, time.Now())
+	if !ok {
+		http.Error(w, "Bad request: invalid code", http.StatusBadRequest)
+		logger.Log.Info("Bad request: invalid code")
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Error when generating recovery codes: "+err.Error(), http.StatusInternalServerError)
+		logger.Log.Info("Error when generating recovery codes: " + err.Error())
+		return
+	}
+
+	err = h.users.EnableTOTP(owner.ID, hashes)
+	if err == nil {
+		_, err = h.users.UseTOTPStep(owner.ID, step)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+	logger.Log.Info("Two-factor authentication enabled for user " + owner.ID)
+	h.audit(r, AuditEvent{Event: AuditTwoFactorEnabled, UserID: owner.ID})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while sending JSON: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Error while sending JSON: ") + err.Error())
+		return
+	}
+}
+
+// DisableTOTP turns 2FA off. A valid code or recovery code is required so a
+// stolen access token alone cannot remove the second factor.
+func (h *handler) DisableTOTP(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var request TOTPCodeRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Bad request body: "+err.Error(), http.StatusBadRequest)
+		logger.Log.Info("Bad request body: " + err.Error())
+		return
+	}
+
+	caller, _ := CallerFromContext(r.Context())
+	owner, err := h.users.ByID(caller.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+	if !owner.TOTPEnabled {
+		http.Error(w, "Bad request: two-factor authentication is not enabled", http.StatusBadRequest)
+		logger.Log.Info("Bad request: two-factor authentication is not enabled")
+		return
+	}
+
+	ok, err := h.checkSecondFactor(owner, request.Code, request.RecoveryCode)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+	if !ok {
+		http.Error(w, "Bad request: invalid code", http.StatusBadRequest)
+		logger.Log.Info("Bad request: invalid code")
+		return
+	}
+
+	err = h.users.DisableTOTP(owner.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+	logger.Log.Info("Two-factor authentication disabled for user " + owner.ID)
+	h.audit(r, AuditEvent{Event: AuditTwoFactorDisabled, UserID: owner.ID})
+
+	w.WriteHeader(http.StatusOK)
+}
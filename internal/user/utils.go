@@ -1,44 +1,35 @@
 package user
 
-import (
-	"database/sql"
-)
+import "net/mail"
 
-func IsUsernameEmailTaken(user *User, db *sql.DB) (bool, error) {
-	var count int
-	if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE username = $1 OR email = $2",
-		user.Username, user.Email).Scan(&count); err != nil {
-		return false, err
+func UserSuitableForRestrictions(lenUsername, lenPassword, lenEmail int) bool {
+	if lenUsername > 32 || lenPassword > 128 || lenEmail > 64 {
+		return false
 	}
-	return count > 0, nil
+	return true
 }
 
-func IdExists(ID int, db *sql.DB) (int, error) {
-	var IdExists int
-	err := db.QueryRow("SELECT COUNT(*) FROM users WHERE user_id = $1", ID).Scan(&IdExists)
-	if err != nil {
-		return 0, err
-	}
-	if IdExists == 0 {
-		return 0, nil
-	}
-	return 1, nil
+// ValidEmail reports whether email is a bare address like reader@example.com,
+// without a display name or angle brackets.
+func ValidEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
 }
 
-func BookExists(ID int, db *sql.DB) (int, error) {
-	var BookExists int
-	err := db.QueryRow("SELECT COUNT(*) FROM books WHERE id = $1", ID).Scan(&BookExists)
-	if err != nil {
-		return 0, err
+func BookPatchSuitableForRestrictions(patch *BookPatch) bool {
+	if patch.Title != nil && (*patch.Title == "" || len(*patch.Title) > 64) {
+		return false
+	}
+	if patch.Author != nil && (*patch.Author == "" || len(*patch.Author) > 64) {
+		return false
 	}
-	if BookExists == 0 {
-		return 0, nil
+	if patch.CoverImage != nil && len(*patch.CoverImage) > 255 {
+		return false
 	}
-	return 1, nil
-}
-
-func UserSuitableForRestrictions(lenUsername, lenPassword, lenEmail int) bool {
-	if lenUsername > 32 || lenPassword > 128 || lenEmail > 64 {
+	if patch.Rating != nil && (*patch.Rating < 1 || *patch.Rating > 10) {
+		return false
+	}
+	if patch.PageCount != nil && (*patch.PageCount < 1 || *patch.PageCount > maxPageCount) {
 		return false
 	}
 	return true
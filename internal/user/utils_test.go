@@ -0,0 +1,22 @@
+package user
+
+import "testing"
+
+func TestValidEmail(t *testing.T) {
+	for email, want := range map[string]bool{
+		"reader@example.com":            true,
+		"first.last+tag@mail.example":   true,
+		"foo":                           false,
+		"":                              false,
+		"@example.com":                  false,
+		"reader@":                       false,
+		"Reader <reader@example.com>":   false,
+		"<reader@example.com>":          false,
+		" reader@example.com":           false,
+		"reader@example.com, other@x.y": false,
+	} {
+		if got := ValidEmail(email); got != want {
+			t.Errorf("ValidEmail(%q) = %v, want %v", email, got, want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/julienschmidt/httprouter"
+	"myLibrary/package/client/mailer"
+	"myLibrary/package/logger"
+	"net/http"
+	"net/url"
+)
+
+const (
+	VerifyEmailUrl        = "/email/verify"
+	ResendVerificationUrl = "/email/verify/resend"
+)
+
+// sendVerification mails user a signed link that confirms their current email.
+// The link carries the email, so it stops working once the email is changed.
+func (h *handler) sendVerification(user *User) error {
+	token, err := h.signPurposeToken(verifyEmailPurpose, user.ID, h.cfg.Key.VerifyTTL, jwt.MapClaims{"email": user.Email})
+	if err != nil {
+		return err
+	}
+
+	link := h.cfg.PublicURL + VerifyEmailUrl + "?token=" + url.QueryEscape(token)
+	return h.mail.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email",
+		Body: fmt.Sprintf("Hello, %s!\n\nTo finish setting up your myLibrary account, confirm your email "+
+			"within %d hours by following the link:\n\n%s",
+			user.Username, int(h.cfg.Key.VerifyTTL.Hours()), link),
+	})
+}
+
+// parseVerificationToken returns the user ID and email a verification link was issued for.
+func (h *handler) parseVerificationToken(tokenString string) (string, string, error) {
+	claims, err := h.parsePurposeToken(tokenString, verifyEmailPurpose)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid or expired link")
+	}
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return "", "", fmt.Errorf("invalid or expired link")
+	}
+	return claims["sub"].(string), email, nil
+}
+
+// VerifyEmail confirms the email from a link sent by sendVerification.
+// Following the same link again is harmless.
+func (h *handler) VerifyEmail(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	userID, email, err := h.parseVerificationToken(r.URL.Query().Get("token"))
+	if err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		logger.Log.Info("Bad request: " + err.Error())
+		return
+	}
+
+	err = h.users.MarkEmailVerified(userID, email)
+	if err != nil {
+		if err == ErrNotFound {
+			http.Error(w, "Bad request: the email of this account has changed", http.StatusBadRequest)
+			logger.Log.Info("Bad request: the email of this account has changed")
+			return
+		}
+		http.Error(w, fmt.Sprintf("Database unavailable: ")+err.Error(), http.StatusServiceUnavailable)
+		logger.Log.Info(fmt.Sprintf("Database unavailable: ") + err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, err = w.Write([]byte("Email verified, you can log in now.\n"))
+	if err != nil {
+		logger.Log.Info("Email verified, but while sending respond: " + err.Error())
+	}
+}
+
+// ResendVerification mails a fresh verification link to an unverified account.
+// Like ForgotPassword it answers the same way for unknown addresses.
+func (h *handler) ResendVerification(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var request ResendVerificationRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.Email == "" {
+		http.Error(w, "Bad request: email is required", http.StatusBadRequest)
+		logger.Log.Info("Bad request: email is required")
+		return
+	}
+
+	owner, err := h.users.ByEmail(request.Email)
+	switch {
+	case err == ErrNotFound:
+		logger.Log.Info("Verification requested for unknown email")
+	case err != nil:
+		logger.Log.Error("Verification was not sent: " + err.Error())
+	case owner.EmailVerified:
+		logger.Log.Info("Verification requested for verified user " + owner.ID)
+	default:
+		err = h.sendVerification(owner)
+		if err != nil {
+			logger.Log.Error("Verification was not sent: " + err.Error())
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,255 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"myLibrary/package/logger"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the pg_advisory_lock key that serializes migration runs
+// of several app instances starting at once.
+const migrationLockID = 4242001
+
+var ErrSchemaAhead = errors.New("database schema is newer than this binary")
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied bool
+}
+
+// Migrations returns the migrations compiled into the binary ordered by version.
+// Files are named NNNN_name.up.sql and NNNN_name.down.sql.
+func Migrations() ([]Migration, error) {
+	return parseMigrations(migrationFiles)
+}
+
+func parseMigrations(files fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("unexpected migration file %s", name)
+		}
+
+		rawVersion, title, ok := strings.Cut(strings.TrimSuffix(name, "."+direction+".sql"), "_")
+		version, err := strconv.Atoi(rawVersion)
+		if !ok || err != nil || version < 1 {
+			return nil, fmt.Errorf("migration file %s has no version prefix", name)
+		}
+
+		body, err := fs.ReadFile(files, path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, exists := byVersion[version]
+		if !exists {
+			migration = &Migration{Version: version, Name: title}
+			byVersion[version] = migration
+		} else if migration.Name != title {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, title)
+		}
+		if direction == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d needs both up and down files", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrate applies every pending migration, each in its own transaction.
+// It returns ErrSchemaAhead without touching anything if the database has
+// a version this binary does not know.
+func Migrate(db *sql.DB) error {
+	return withMigrationLock(db, func(conn *sql.Conn) error {
+		migrations, applied, err := loadState(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			if applied[migration.Version] {
+				continue
+			}
+			logger.Log.Info(fmt.Sprintf("Applying migration %04d_%s", migration.Version, migration.Name))
+			err := inTx(conn, func(tx *sql.Tx) error {
+				if _, err := tx.Exec(migration.Up); err != nil {
+					return err
+				}
+				_, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)",
+					migration.Version, migration.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Rollback reverts the last steps applied migrations.
+func Rollback(db *sql.DB, steps int) error {
+	return withMigrationLock(db, func(conn *sql.Conn) error {
+		migrations, applied, err := loadState(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := migrations[i]
+			if !applied[migration.Version] {
+				continue
+			}
+			logger.Log.Info(fmt.Sprintf("Reverting migration %04d_%s", migration.Version, migration.Name))
+			err := inTx(conn, func(tx *sql.Tx) error {
+				if _, err := tx.Exec(migration.Down); err != nil {
+					return err
+				}
+				_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// Status reports which of the known migrations are applied.
+func Status(db *sql.DB) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := withMigrationLock(db, func(conn *sql.Conn) error {
+		migrations, applied, err := loadState(conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			statuses = append(statuses, MigrationStatus{Migration: migration, Applied: applied[migration.Version]})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// CheckSchema fails if migrations are pending or the schema is ahead of the binary.
+// It is used at startup when migrations are not applied automatically.
+func CheckSchema(db *sql.DB) error {
+	statuses, err := Status(db)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if !status.Applied {
+			return fmt.Errorf("migration %04d_%s is not applied", status.Version, status.Name)
+		}
+	}
+	return nil
+}
+
+func withMigrationLock(db *sql.DB, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+
+	_, err = conn.ExecContext(context.Background(), `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			name       TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT now()
+		)`)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func loadState(conn *sql.Conn) ([]Migration, map[int]bool, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, nil, err
+	}
+	known := make(map[int]bool, len(migrations))
+	for _, migration := range migrations {
+		known[migration.Version] = true
+	}
+
+	rows, err := conn.QueryContext(context.Background(), "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, nil, err
+		}
+		if !known[version] {
+			return nil, nil, fmt.Errorf("%w: unknown version %d", ErrSchemaAhead, version)
+		}
+		applied[version] = true
+	}
+	return migrations, applied, rows.Err()
+}
+
+func inTx(conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations are embedded")
+	}
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("migration %d has version %d; versions must have no gaps", i+1, migration.Version)
+		}
+		if migration.Name == "" || migration.Up == "" || migration.Down == "" {
+			t.Errorf("migration %d: %+v", migration.Version, migration)
+		}
+	}
+}
+
+func TestParseMigrations(t *testing.T) {
+	files := fstest.MapFS{
+		"migrations/0010_later.up.sql":   {Data: []byte("up 10")},
+		"migrations/0010_later.down.sql": {Data: []byte("down 10")},
+		"migrations/0002_books.up.sql":   {Data: []byte("up 2")},
+		"migrations/0002_books.down.sql": {Data: []byte("down 2")},
+		"migrations/0001_init.down.sql":  {Data: []byte("down 1")},
+		"migrations/0001_init.up.sql":    {Data: []byte("up 1")},
+	}
+	migrations, err := parseMigrations(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Migration{
+		{Version: 1, Name: "init", Up: "up 1", Down: "down 1"},
+		{Version: 2, Name: "books", Up: "up 2", Down: "down 2"},
+		{Version: 10, Name: "later", Up: "up 10", Down: "down 10"},
+	}
+	if len(migrations) != len(want) {
+		t.Fatalf("got %+v, want %+v", migrations, want)
+	}
+	for i := range want {
+		if migrations[i] != want[i] {
+			t.Errorf("migration %d: got %+v, want %+v", i, migrations[i], want[i])
+		}
+	}
+}
+
+func TestParseMigrationsRejects(t *testing.T) {
+	for name, files := range map[string]fstest.MapFS{
+		"no down file": {
+			"migrations/0001_init.up.sql": {Data: []byte("up")},
+		},
+		"not sql": {
+			"migrations/0001_init.up.sql":   {Data: []byte("up")},
+			"migrations/0001_init.down.sql": {Data: []byte("down")},
+			"migrations/README.md":          {Data: []byte("notes")},
+		},
+		"no version": {
+			"migrations/init.up.sql":   {Data: []byte("up")},
+			"migrations/init.down.sql": {Data: []byte("down")},
+		},
+		"version zero": {
+			"migrations/0000_init.up.sql":   {Data: []byte("up")},
+			"migrations/0000_init.down.sql": {Data: []byte("down")},
+		},
+		"two names": {
+			"migrations/0001_init.up.sql":     {Data: []byte("up")},
+			"migrations/0001_create.down.sql": {Data: []byte("down")},
+		},
+	} {
+		if migrations, err := parseMigrations(files); err == nil {
+			t.Errorf("%s: got %+v, want an error", name, migrations)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS users (
+    user_id  SERIAL PRIMARY KEY,
+    username VARCHAR(32)  NOT NULL UNIQUE,
+    password VARCHAR(256) NOT NULL,
+    email    VARCHAR(64)  NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS books (
+    id              SERIAL PRIMARY KEY,
+    title           VARCHAR(64)  NOT NULL,
+    author          VARCHAR(64)  NOT NULL,
+    cover_image_url VARCHAR(255) NOT NULL DEFAULT '',
+    date_added      TIMESTAMP    NOT NULL DEFAULT now(),
+    comment         TEXT,
+    rating          INTEGER CHECK (rating BETWEEN 0 AND 10),
+    user_id         INTEGER      NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    is_read         BOOLEAN      NOT NULL DEFAULT false
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS books_search_idx;
+DROP INDEX IF EXISTS books_user_is_read_date_idx;
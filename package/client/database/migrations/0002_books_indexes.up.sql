@@ -0,0 +1,4 @@
+CREATE INDEX IF NOT EXISTS books_user_is_read_date_idx ON books (user_id, is_read, date_added, id);
+
+CREATE INDEX IF NOT EXISTS books_search_idx ON books
+    USING GIN (to_tsvector('simple', title || ' ' || author || ' ' || COALESCE(comment, '')));
@@ -0,0 +1,10 @@
+-- Accounts at external OpenID Connect providers that sign in as a user.
+CREATE TABLE user_identities (
+    issuer     VARCHAR(255) NOT NULL,
+    subject    VARCHAR(255) NOT NULL,
+    user_id    INTEGER      NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX user_identities_user_idx ON user_identities (user_id);
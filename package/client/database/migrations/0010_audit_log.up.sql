@@ -0,0 +1,29 @@
+-- user_id and actor_id have no foreign keys: entries outlive deleted users.
+CREATE TABLE audit_log (
+    id          BIGSERIAL PRIMARY KEY,
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    event       VARCHAR(64) NOT NULL,
+    user_id     INTEGER,
+    actor_id    INTEGER,
+    ip          VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent  TEXT        NOT NULL DEFAULT '',
+    details     JSONB       NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX audit_log_user_idx ON audit_log (user_id, id);
+CREATE INDEX audit_log_actor_idx ON audit_log (actor_id, id);
+
+-- The log is append-only.
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_no_update_delete
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+CREATE TRIGGER audit_log_no_truncate
+    BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
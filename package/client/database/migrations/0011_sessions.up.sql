@@ -0,0 +1,10 @@
+ALTER TABLE token_families
+    ADD COLUMN user_agent   TEXT        NOT NULL DEFAULT '',
+    ADD COLUMN ip           VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN last_seen_at TIMESTAMPTZ;
+
+UPDATE token_families SET last_seen_at = created_at;
+
+ALTER TABLE token_families
+    ALTER COLUMN last_seen_at SET NOT NULL,
+    ALTER COLUMN last_seen_at SET DEFAULT now();
@@ -0,0 +1,14 @@
+ALTER TABLE books ADD COLUMN is_read BOOLEAN NOT NULL DEFAULT false;
+-- Books being read or abandoned go back to the wishlist.
+UPDATE books SET is_read = true WHERE status = 'finished';
+
+DROP INDEX IF EXISTS books_user_status_date_idx;
+CREATE INDEX IF NOT EXISTS books_user_is_read_date_idx ON books (user_id, is_read, date_added, id);
+
+ALTER TABLE books
+    DROP COLUMN IF EXISTS finished_at,
+    DROP COLUMN IF EXISTS started_at,
+    DROP COLUMN IF EXISTS progress_percent,
+    DROP COLUMN IF EXISTS current_page,
+    DROP COLUMN IF EXISTS page_count,
+    DROP COLUMN IF EXISTS status;
@@ -0,0 +1,16 @@
+ALTER TABLE books
+    ADD COLUMN status           VARCHAR(16) NOT NULL DEFAULT 'want_to_read'
+        CHECK (status IN ('want_to_read', 'reading', 'finished', 'abandoned')),
+    ADD COLUMN page_count       INTEGER CHECK (page_count > 0),
+    -- Progress is either a page or a percentage, never both.
+    ADD COLUMN current_page     INTEGER CHECK (current_page >= 0),
+    ADD COLUMN progress_percent INTEGER CHECK (progress_percent BETWEEN 0 AND 100),
+    ADD COLUMN started_at       DATE,
+    ADD COLUMN finished_at      DATE,
+    ADD CHECK (current_page IS NULL OR progress_percent IS NULL);
+
+UPDATE books SET status = 'finished', finished_at = date_added::date, progress_percent = 100 WHERE is_read;
+
+DROP INDEX IF EXISTS books_user_is_read_date_idx;
+ALTER TABLE books DROP COLUMN is_read;
+CREATE INDEX books_user_status_date_idx ON books (user_id, status, date_added, id);
@@ -0,0 +1,13 @@
+CREATE TABLE reading_sessions (
+    id         SERIAL PRIMARY KEY,
+    book_id    INTEGER     NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+    -- user_id is the owner of the book, kept here to compute the pace of a user.
+    user_id    INTEGER     NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    started_at TIMESTAMPTZ NOT NULL,
+    ended_at   TIMESTAMPTZ NOT NULL,
+    pages_read INTEGER     NOT NULL CHECK (pages_read >= 0),
+    CHECK (ended_at > started_at)
+);
+
+CREATE INDEX reading_sessions_book_idx ON reading_sessions (book_id, id);
+CREATE INDEX reading_sessions_user_idx ON reading_sessions (user_id);
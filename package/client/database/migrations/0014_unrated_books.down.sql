@@ -0,0 +1,5 @@
+ALTER TABLE books
+    DROP CONSTRAINT IF EXISTS books_rating_check,
+    ADD CONSTRAINT books_rating_check CHECK (rating BETWEEN 0 AND 10);
+
+UPDATE books SET rating = 0 WHERE rating IS NULL AND status = 'finished';
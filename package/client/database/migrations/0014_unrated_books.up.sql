@@ -0,0 +1,6 @@
+-- A finished book without a rating has NULL, like books that are not finished.
+UPDATE books SET rating = NULL WHERE rating = 0;
+
+ALTER TABLE books
+    DROP CONSTRAINT IF EXISTS books_rating_check,
+    ADD CONSTRAINT books_rating_check CHECK (rating BETWEEN 1 AND 10);
@@ -0,0 +1,104 @@
+package mailer
+
+import (
+	"fmt"
+	"myLibrary/internal/config"
+	"myLibrary/package/logger"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers plain text messages to users.
+type Mailer interface {
+	Send(message Message) error
+}
+
+// Init builds the mailer chosen by mail.driver: "smtp" sends real mail,
+// "log" (the default) appends messages to mail.log_file or the app log.
+func Init(config *config.Config) Mailer {
+	switch config.Mail.Driver {
+	case "smtp":
+		logger.Log.Info(fmt.Sprintf("Sending mail through %s:%d", config.Mail.Host, config.Mail.Port))
+		return &SMTPMailer{
+			Addr:     net.JoinHostPort(config.Mail.Host, strconv.Itoa(config.Mail.Port)),
+			Host:     config.Mail.Host,
+			Username: config.Mail.Username,
+			Password: config.Mail.Password,
+			From:     config.Mail.From,
+		}
+	case "log", "":
+		logger.Log.Info("Mail is not sent, messages are written to the log")
+		return &LogMailer{Path: config.Mail.LogFile}
+	default:
+		logger.Log.Fatal("Unknown mail driver " + config.Mail.Driver)
+		return nil
+	}
+}
+
+type SMTPMailer struct {
+	Addr     string
+	Host     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(message Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, []string{message.To}, m.format(message))
+}
+
+func (m *SMTPMailer) format(message Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + m.From + "\r\n")
+	b.WriteString("To: " + message.To + "\r\n")
+	b.WriteString("Subject: " + message.Subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// LogMailer is the stand-in for local development: it appends every message
+// to the file at Path, or to the app log when Path is empty.
+type LogMailer struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (m *LogMailer) Send(message Message) error {
+	text := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", message.To, message.Subject, message.Body)
+	if m.Path == "" {
+		logger.Log.Info("Mail:\n" + text)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(text + "----\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}